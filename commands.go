@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/heroku/babycheck/storage"
+)
+
+const commandsUsage = `Usage: babycheck <command> [arguments]
+
+Commands:
+  check [-all] [-repair issue=fix,...] <username>
+        Report the invalid event sequences of a user and apply the chosen fixes
+`
+
+// runCommand runs an admin command and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "check":
+		return checkCommand(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, commandsUsage)
+		return 2
+	}
+}
+
+// checkCommand lists the consistency issues of a user's events. With -all
+// the suggested fix of every issue is applied, with -repair the given ones.
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	all := flags.Bool("all", false, "apply the suggested fix of every issue")
+	repair := flags.String("repair", "", "comma-separated issue=fix repairs to apply")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, commandsUsage)
+		return 2
+	}
+
+	users := storage.NewUserStorage()
+	if users == nil {
+		fmt.Fprintln(os.Stderr, "Erreur de connexion à la base de données")
+		return 1
+	}
+	user, err := users.GetUser(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "User not found: %s\n", flags.Arg(0))
+		return 1
+	}
+	store := storage.NewStorage(user.ID)
+	if store == nil {
+		fmt.Fprintln(os.Stderr, "Erreur de connexion au stockage")
+		return 1
+	}
+	store.SetLocation(user.Location())
+
+	now := time.Now().UnixMilli()
+	issues := store.CheckConsistency(now)
+	for _, issue := range issues {
+		at := time.UnixMilli(issue.Event.Timestamp).In(store.Location()).Format("2006-01-02 15:04")
+		fmt.Printf("%s\t%s\t%s\n", issue.ID, at, issue.Message)
+		for _, fix := range issue.Fixes {
+			fmt.Printf("\t%s: %s\n", fix.ID, fix.Description)
+		}
+	}
+	fmt.Printf("%d issue(s)\n", len(issues))
+
+	choices := make([]storage.RepairChoice, 0)
+	if *all {
+		for _, issue := range issues {
+			choices = append(choices, storage.RepairChoice{IssueID: issue.ID, Fix: issue.Fixes[0].ID})
+		}
+	} else if *repair != "" {
+		for _, value := range strings.Split(*repair, ",") {
+			parts := strings.SplitN(value, "=", 2)
+			if len(parts) != 2 {
+				fmt.Fprintf(os.Stderr, "Invalid repair %q, expected issue=fix\n", value)
+				return 2
+			}
+			choices = append(choices, storage.RepairChoice{IssueID: parts[0], Fix: parts[1]})
+		}
+	}
+	if len(choices) == 0 {
+		return 0
+	}
+
+	failed := false
+	results, remaining := store.Repair(choices, "cli", now)
+	for _, result := range results {
+		fmt.Printf("%s\t%s\t%s %s\n", result.IssueID, result.Fix, result.Status, result.Error)
+		failed = failed || result.Status == "error"
+	}
+	fmt.Printf("%d issue(s) left\n", len(remaining))
+	if failed {
+		return 1
+	}
+	return 0
+}
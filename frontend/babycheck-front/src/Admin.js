@@ -51,6 +51,17 @@ export default function Admin({ user, onLogout }) {
         setLoading(false);
     };
 
+    const fetchMoreUserData = async (userName) => {
+        const current = allUsersData[userName];
+        const response = await Api.getAllUsersData(userName, current.next_cursor);
+        const page = response.data && response.data[userName];
+        if (!page) return;
+        setAllUsersData({
+            ...allUsersData,
+            [userName]: { ...page, events: [...current.events, ...page.events] },
+        });
+    };
+
     const fetchData = () => {
         switch (viewMode) {
             case 'my-data':
@@ -380,7 +391,7 @@ export default function Admin({ user, onLogout }) {
                     return (
                         <div key={userName} style={{ marginBottom: '40px', border: '1px solid #ccc', padding: '20px', borderRadius: '8px' }}>
                             <h3 style={{ marginTop: '0', color: '#61dafb' }}>
-                                {userName} ({events.length} événements)
+                                {userName} ({userData.count} événements)
                             </h3>
                             <div style={{ marginBottom: '15px', fontSize: '14px', color: '#666' }}>
                                 <div style={{ marginBottom: '8px' }}>
@@ -415,7 +426,7 @@ export default function Admin({ user, onLogout }) {
                                         </tr>
                                     </thead>
                                     <tbody>
-                                        {events.slice(0, 10).map((event, index) => (
+                                        {events.map((event, index) => (
                                             <tr key={event.id || index} style={{ borderBottom: '1px solid #f0f0f0' }}>
                                                 <td style={{ padding: '6px' }}>
                                                     {new Date(event.timestamp).toLocaleString()}
@@ -432,9 +443,10 @@ export default function Admin({ user, onLogout }) {
                                 </table>
                             )}
                             
-                            {events.length > 10 && (
+                            {userData.next_cursor && (
                                 <p style={{ marginTop: '10px', fontStyle: 'italic', fontSize: '12px' }}>
-                                    ... et {events.length - 10} autres événements
+                                    ... et {userData.count - events.length} autres événements{' '}
+                                    <button onClick={() => fetchMoreUserData(userName)}>Afficher plus</button>
                                 </p>
                             )}
                         </div>
@@ -32,8 +32,20 @@ export default function AuthForm({ onAuthenticated }) {
 
             if (response.ok) {
                 localStorage.setItem('babycheck_token', response.data.token);
-                localStorage.setItem('babycheck_user', JSON.stringify(response.data.user));
-                onAuthenticated(response.data.user, response.data.token);
+                const user = response.data.user;
+                // Day boundaries follow the user's time zone, default it to
+                // the one of the browser on first login
+                const browserTimeZone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+                if (!user.time_zone && browserTimeZone) {
+                    try {
+                        await Api.setTimeZone(browserTimeZone);
+                        user.time_zone = browserTimeZone;
+                    } catch (err) {
+                        // The default zone is used until set in the profile
+                    }
+                }
+                localStorage.setItem('babycheck_user', JSON.stringify(user));
+                onAuthenticated(user, response.data.token);
             } else {
                 setError(response.data.error || 'Erreur de connexion');
             }
@@ -167,6 +167,14 @@ function BabyTracker({ user, onLogout }) {
     fetchData();
   }, [fetchData]);
 
+  // Refresh when the other parent logs or edits an event
+  useEffect(() => {
+    const source = Api.streamEvents(() => {
+      refreshTimelineAndState();
+    });
+    return () => source.close();
+  }, [refreshTimelineAndState]);
+
   let gridClass = "actions-container";
   let sleepClass = "sleep-button";
   const isSleeping = babystate === "sleep";
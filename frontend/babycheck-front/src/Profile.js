@@ -14,6 +14,8 @@ export default function Profile({ user, onLogout }) {
     const [showVerificationInput, setShowVerificationInput] = useState(false);
     const [pendingEmail, setPendingEmail] = useState('');
     const [loading, setLoading] = useState(false);
+    const [author, setAuthor] = useState(Api.getAuthor());
+    const [timeZoneMessage, setTimeZoneMessage] = useState('');
 
     const handleLogout = () => {
         if (window.confirm('Êtes-vous sûr de vouloir vous déconnecter ?')) {
@@ -47,6 +49,24 @@ export default function Profile({ user, onLogout }) {
         fetchCurrentUser();
     }, []);
 
+    const browserTimeZone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    const timeZones = typeof Intl.supportedValuesOf === 'function'
+        ? Intl.supportedValuesOf('timeZone')
+        : [browserTimeZone];
+    const currentTimeZone = currentUser?.time_zone || '';
+
+    const changeTimeZone = async (timeZone) => {
+        try {
+            await Api.setTimeZone(timeZone);
+            setCurrentUser({ ...currentUser, time_zone: timeZone });
+            setTimeZoneMessage('Fuseau horaire mis à jour');
+        } catch (error) {
+            setTimeZoneMessage(error.message || 'Erreur lors du changement de fuseau horaire');
+        } finally {
+            setTimeout(() => setTimeZoneMessage(''), 5000);
+        }
+    };
+
     const sendVerificationEmail = async () => {
         if (!email || !email.includes('@')) {
             setEmailMessage('Veuillez entrer une adresse email valide');
@@ -204,6 +224,77 @@ export default function Profile({ user, onLogout }) {
                     </div>
                 </div>
 
+                <div style={{ marginBottom: '25px' }}>
+                    <h2 style={{ 
+                        fontSize: '18px', 
+                        marginBottom: '15px',
+                        color: '#61dafb',
+                        borderBottom: '1px solid #555',
+                        paddingBottom: '5px'
+                    }}>
+                        Parent sur cet appareil
+                    </h2>
+                    <input
+                        type="text"
+                        value={author}
+                        maxLength={50}
+                        placeholder="Votre prénom"
+                        onChange={(e) => {
+                            setAuthor(e.target.value);
+                            Api.setAuthor(e.target.value.trim());
+                        }}
+                        style={{
+                            width: '100%',
+                            padding: '10px',
+                            borderRadius: '8px',
+                            border: '1px solid #555',
+                            backgroundColor: '#2a2e37',
+                            color: 'white',
+                            boxSizing: 'border-box'
+                        }}
+                    />
+                </div>
+
+                <div style={{ marginBottom: '25px' }}>
+                    <h2 style={{ 
+                        fontSize: '18px', 
+                        marginBottom: '15px',
+                        color: '#61dafb',
+                        borderBottom: '1px solid #555',
+                        paddingBottom: '5px'
+                    }}>
+                        Fuseau horaire
+                    </h2>
+                    <select
+                        value={currentTimeZone}
+                        onChange={(e) => changeTimeZone(e.target.value)}
+                        style={{
+                            width: '100%',
+                            padding: '10px',
+                            borderRadius: '8px',
+                            border: '1px solid #555',
+                            backgroundColor: '#2a2e37',
+                            color: 'white',
+                            boxSizing: 'border-box'
+                        }}
+                    >
+                        {!currentTimeZone && <option value="">Par défaut (Europe/Paris)</option>}
+                        {currentTimeZone && !timeZones.includes(currentTimeZone) && (
+                            <option value={currentTimeZone}>{currentTimeZone}</option>
+                        )}
+                        {timeZones.map((timeZone) => (
+                            <option key={timeZone} value={timeZone}>
+                                {timeZone}{timeZone === browserTimeZone ? ' (cet appareil)' : ''}
+                            </option>
+                        ))}
+                    </select>
+                    {timeZoneMessage && (
+                        <div style={{ marginTop: '10px', fontSize: '14px', color: '#ccc' }}>
+                            {timeZoneMessage}
+                        </div>
+                    )}
+                </div>
+
                 <div style={{ marginBottom: '25px' }}>
                     <h2 style={{ 
                         fontSize: '18px', 
@@ -16,6 +16,7 @@ const periodLabels = {
 
 function Stats() {
   const [stats, setStats] = useState(null);
+  const [workload, setWorkload] = useState(null);
   const [period, setPeriod] = useState('day');
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState(null);
@@ -24,8 +25,12 @@ function Stats() {
     setLoading(true);
     setError(null);
     try {
-      const response = await Api.getStats(selectedPeriod);
+      const [response, workloadResponse] = await Promise.all([
+        Api.getStats(selectedPeriod),
+        Api.getWorkload(selectedPeriod).catch(() => null)
+      ]);
       setStats(response);
+      setWorkload(workloadResponse);
     } catch (error) {
       console.error('Error fetching stats:', error);
       setError(error.message);
@@ -180,6 +185,21 @@ function Stats() {
                   <p style={{ margin: 0, fontSize: '14px', color: '#7dd3fc', fontWeight: 'bold' }}>
                     Total: {stats.left_boob_count + stats.right_boob_count} fois ({toHHMM((stats.left_boob_duration + stats.right_boob_duration) / 1000)})
                   </p>
+                  {stats.feed_count > 1 && (
+                    <p style={{ margin: 0, fontSize: '14px', color: '#e0e0e0' }}>
+                      <strong>Entre deux tétées:</strong> {toHHMM(stats.average_feed_interval / 1000)} en moyenne ({toHHMM(stats.min_feed_interval / 1000)} - {toHHMM(stats.max_feed_interval / 1000)})
+                    </p>
+                  )}
+                  {stats.cluster_feed_count > 0 && (
+                    <p style={{ margin: 0, fontSize: '14px', color: '#e0e0e0' }}>
+                      <strong>Tétées groupées:</strong> {stats.cluster_feed_count} fois
+                    </p>
+                  )}
+                  {stats.next_side && (
+                    <p style={{ margin: 0, fontSize: '14px', color: '#e879f9', fontWeight: 'bold' }}>
+                      Prochain sein: {stats.next_side === 'leftBoob' ? 'gauche' : 'droit'}
+                    </p>
+                  )}
                 </div>
               </div>
 
@@ -202,6 +222,30 @@ function Stats() {
                 </div>
               </div>
 
+              {workload && workload.caregivers.length > 0 && (
+                <div style={{ 
+                  backgroundColor: '#2d3748', 
+                  padding: '16px', 
+                  borderRadius: '8px',
+                  border: '1px solid #4a5568'
+                }}>
+                  <h4 style={{ margin: '0 0 8px 0', color: '#fbbf24', fontSize: '16px' }}>
+                    👪 Répartition
+                  </h4>
+                  {workload.caregivers.map(caregiver => (
+                    <p key={caregiver.author} style={{ margin: '0 0 4px 0', fontSize: '14px', color: '#e0e0e0' }}>
+                      <strong>{caregiver.author === 'None' ? 'Inconnu' : caregiver.author}:</strong> {Math.round(caregiver.share * 100)}% — 
+                      {' '}{caregiver.feed_count} tétées ({caregiver.night_feed_count} la nuit),
+                      {' '}{caregiver.diaper_count} changes ({caregiver.night_diaper_count} la nuit),
+                      {' '}{caregiver.settling_count} couchers ({caregiver.night_settling_count} la nuit)
+                    </p>
+                  ))}
+                  <p style={{ margin: 0, fontSize: '12px', color: '#a0a0a0' }}>
+                    Les tâches de nuit comptent {workload.night_weight} fois.
+                  </p>
+                </div>
+              )}
+
             </div>
           </div>
         ) : (
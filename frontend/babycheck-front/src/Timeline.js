@@ -43,6 +43,13 @@ const labelMap = {
     'wake': 'Est éveillé',
 }
 
+// Events not tapped by a parent are shown in italics with their origin
+const sourceLabels = {
+    'inferred': 'déduit',
+    'imported': 'importé',
+    'auto-closed': 'fermé auto',
+}
+
 // Helper functions for switching event types
 const canSwitchEvent = (eventName) => {
     return ['pee', 'poop', 'leftBoob', 'rightBoob'].includes(eventName);
@@ -67,11 +74,36 @@ export default function Timeline(props) {
     const [showModal, setShowModal] = React.useState(false);
     const [modalEvent, setModalEvent] = React.useState(null); // event to display in modal
     const [touchTimer, setTouchTimer] = React.useState(null);
+    // Change rejected because the event was modified meanwhile, with the
+    // server version
+    const [conflict, setConflict] = React.useState(null);
     const events = props.events;
     const { start, stop } = props;
     if (events.length === 0) {
         return <div></div>
     }
+    const closeModal = () => {
+        setShowModal(false);
+        setModalEvent(null);
+        setConflict(null);
+    };
+
+    // Applies the update or delete to the version of the event given. When
+    // someone else changed it first, the caregiver sees the current version
+    // and can retry on it or discard their change.
+    const applyChange = async (event, change) => {
+        const body = change.type === 'update'
+            ? await Api.updateEvent(event, change.newAction)
+            : await Api.delete(event);
+        if (body.status === 409 || body.status === 404) {
+            setConflict({ ...change, current: body.current || null });
+            return;
+        }
+        closeModal();
+        // Refresh data
+        window.location.reload();
+    };
+
     const sorted = events.sort((a, b) => b.timestamp - a.timestamp);
     const first = sorted[0];
     const last = sorted[sorted.length - 1];
@@ -98,7 +130,7 @@ export default function Timeline(props) {
                 <div className="modal-content" style={{ position: 'relative' }}>
                     <button 
                         className="close-button"
-                        onClick={() => setShowModal(false)}
+                        onClick={closeModal}
                         style={{
                             position: 'absolute',
                             top: '15px',
@@ -128,7 +160,64 @@ export default function Timeline(props) {
                             à {formatDate(modalEvent.timestamp)}
                         </p>
                         
-                        <div style={{ 
+                        {conflict && (
+                            <div style={{
+                                display: 'flex',
+                                flexDirection: 'column',
+                                gap: '12px',
+                                alignItems: 'center'
+                            }}>
+                                {conflict.current ? (
+                                    <p style={{ margin: 0, fontSize: '14px' }}>
+                                        Cet événement a été modifié entre-temps : {labelMap[conflict.current.name]} à {formatDate(conflict.current.timestamp)}
+                                    </p>
+                                ) : (
+                                    <p style={{ margin: 0, fontSize: '14px' }}>
+                                        Cet événement a été supprimé entre-temps
+                                    </p>
+                                )}
+                                {conflict.current && (
+                                    <button
+                                        style={{
+                                            padding: '10px 16px',
+                                            backgroundColor: '#7dd3fc',
+                                            color: '#1a202c',
+                                            border: 'none',
+                                            borderRadius: '8px',
+                                            cursor: 'pointer',
+                                            fontSize: '12px',
+                                            fontWeight: 'bold',
+                                            width: '280px'
+                                        }}
+                                        onClick={() => applyChange(conflict.current, conflict)}
+                                    >
+                                        {conflict.type === 'update' ? `Changer quand même en ${labelMap[conflict.newAction]}` : 'Supprimer quand même'}
+                                    </button>
+                                )}
+                                <button
+                                    style={{
+                                        padding: '10px 16px',
+                                        backgroundColor: '#4a5568',
+                                        color: 'white',
+                                        border: 'none',
+                                        borderRadius: '8px',
+                                        cursor: 'pointer',
+                                        fontSize: '12px',
+                                        fontWeight: 'bold',
+                                        width: '280px'
+                                    }}
+                                    onClick={() => {
+                                        closeModal();
+                                        // Show the current version
+                                        window.location.reload();
+                                    }}
+                                >
+                                    Abandonner ma modification
+                                </button>
+                            </div>
+                        )}
+
+                        {!conflict && <div style={{ 
                             display: 'flex', 
                             flexDirection: 'column', 
                             gap: '12px', 
@@ -154,14 +243,7 @@ export default function Timeline(props) {
                                         textOverflow: 'ellipsis',
                                         overflow: 'hidden'
                                     }}
-                                    onClick={async () => {
-                                        const newAction = getSwitchTarget(modalEvent.name);
-                                        await Api.updateEvent(modalEvent.timestamp, newAction);
-                                        setShowModal(false);
-                                        setModalEvent(null);
-                                        // Refresh data
-                                        window.location.reload();
-                                    }}
+                                    onClick={() => applyChange(modalEvent, { type: 'update', newAction: getSwitchTarget(modalEvent.name) })}
                                 >
                                     <FontAwesomeIcon icon={faExchangeAlt} />
                                     {getSwitchLabel(modalEvent.name)}
@@ -186,20 +268,13 @@ export default function Timeline(props) {
                                     display: 'flex',
                                     alignItems: 'center'
                                 }}
-                                onClick={async () => { 
-                                    // delete event
-                                    Api.delete(modalEvent.timestamp);
-                                    setShowModal(false);
-                                    setModalEvent(null);
-                                    // Refresh data
-                                    window.location.reload();
-                                }}
+                                onClick={() => applyChange(modalEvent, { type: 'delete' })}
                                 onMouseOver={(e) => e.target.style.backgroundColor = '#e53e3e'}
                                 onMouseOut={(e) => e.target.style.backgroundColor = '#f56565'}
                             >
                                 Supprimer
                             </button>
-                        </div>
+                        </div>}
                     </div>
                 </div>
             </div>
@@ -240,7 +315,9 @@ export default function Timeline(props) {
                             }
                         }}
                         >
-                        <div>{labelMap[event.name]}</div>
+                        <div style={event.source && event.source !== 'recorded' ? { fontStyle: 'italic' } : undefined}>
+                            {labelMap[event.name]}{sourceLabels[event.source] && ` (${sourceLabels[event.source]})`}
+                        </div>
                         {<div>{formatDate(event.timestamp)}</div>}
                     </div>
                 })
@@ -9,7 +9,25 @@ class Api {
 
     getAuthHeaders() {
         const token = localStorage.getItem('babycheck_token');
-        return token ? { 'Authorization': `Bearer ${token}` } : {};
+        const headers = token ? { 'Authorization': `Bearer ${token}` } : {};
+        // Caregiver using this device, recorded as author of the events
+        const author = this.getAuthor();
+        if (author) {
+            headers['X-Author'] = author;
+        }
+        return headers;
+    }
+
+    getAuthor() {
+        return localStorage.getItem('babycheck_author') || '';
+    }
+
+    setAuthor(author) {
+        if (author) {
+            localStorage.setItem('babycheck_author', author);
+        } else {
+            localStorage.removeItem('babycheck_author');
+        }
     }
 
     async ping() {
@@ -18,14 +36,16 @@ class Api {
         return body.message;
     }
 
-    async remote(action) {
+    async remote(action, timestamp = null) {
         try {
+            // timestamp (ms) backdates the action, now when omitted
             const response = await fetch(`${this.baseUrl}/remote/${action}`, { 
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
                     ...this.getAuthHeaders()
                 },
+                body: timestamp ? JSON.stringify({ timestamp }) : undefined,
             });
             const body = await response.json();
             return body;
@@ -54,36 +74,243 @@ class Api {
         }
     }
 
-    async delete(eventTs) {
+    async getStatus() {
+        try {
+            const response = await fetch(`${this.baseUrl}/status`, {
+                headers: this.getAuthHeaders()
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    // State of the baby at a past timestamp in milliseconds
+    async getState(at) {
+        try {
+            const response = await fetch(`${this.baseUrl}/state?at=${at}`, {
+                headers: this.getAuthHeaders()
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    // Subscribes to the created, updated and deleted event notifications.
+    // Each connection opens with a fresh single-use stream token, so the
+    // stream reconnects itself once the server closes it.
+    // Returns a subscription, close it to unsubscribe.
+    streamEvents(onNotification) {
+        let source = null;
+        let retry = null;
+        let closed = false;
+
+        const connect = async () => {
+            let token;
+            try {
+                const response = await fetch(`${this.baseUrl}/events/stream-token`, {
+                    method: 'POST',
+                    headers: this.getAuthHeaders()
+                });
+                token = (await response.json()).token;
+            } catch (e) {
+                console.error(e);
+            }
+            if (closed) return;
+            if (!token) {
+                retry = setTimeout(connect, 5000);
+                return;
+            }
+
+            source = new EventSource(`${this.baseUrl}/events/stream?stream_token=${encodeURIComponent(token)}`);
+            ['created', 'updated', 'deleted'].forEach((type) => {
+                source.addEventListener(type, (e) => {
+                    try {
+                        onNotification(JSON.parse(e.data));
+                    } catch (err) {
+                        console.error(err);
+                    }
+                });
+            });
+            // The token is used up, reconnecting needs a new one
+            source.onerror = () => {
+                source.close();
+                if (!closed) retry = setTimeout(connect, 5000);
+            };
+        };
+        connect();
+
+        return {
+            close() {
+                closed = true;
+                clearTimeout(retry);
+                if (source) source.close();
+            }
+        };
+    }
+
+    // Sends the operations recorded offline. Each operation needs a client_id
+    // generated once, so the batch can be retried safely.
+    async sync(operations, since = 0) {
+        try {
+            const response = await fetch(`${this.baseUrl}/sync`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...this.getAuthHeaders()
+                },
+                body: JSON.stringify({ operations, since })
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    async checkConsistency() {
+        try {
+            const response = await fetch(`${this.baseUrl}/consistency`, {
+                headers: this.getAuthHeaders()
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    // repairs is a list of { issue_id, fix } among the fixes of checkConsistency
+    async repairEvents(repairs) {
+        try {
+            const response = await fetch(`${this.baseUrl}/consistency/repair`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...this.getAuthHeaders()
+                },
+                body: JSON.stringify({ repairs })
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    async getAuditLog(limit = 100) {
+        try {
+            const response = await fetch(`${this.baseUrl}/audit?limit=${limit}`, {
+                headers: this.getAuthHeaders()
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    async queryEvents(params = {}) {
+        try {
+            const query = new URLSearchParams();
+            Object.entries(params).forEach(([key, value]) => {
+                if (value !== undefined && value !== '') {
+                    query.append(key, Array.isArray(value) ? value.join(',') : value);
+                }
+            });
+            const response = await fetch(`${this.baseUrl}/events?${query}`, {
+                headers: this.getAuthHeaders()
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    async getSessions(start, end, type = '') {
+        try {
+            const params = new URLSearchParams({ start, end });
+            if (type) {
+                params.append('type', type);
+            }
+            const response = await fetch(`${this.baseUrl}/sessions?${params}`, {
+                headers: this.getAuthHeaders()
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    // Adds a past session, type is sleep, leftBoob or rightBoob. A 409
+    // response lists the overlapping sessions.
+    async createSession(type, start, end, note = '') {
+        try {
+            const response = await fetch(`${this.baseUrl}/sessions`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...this.getAuthHeaders()
+                },
+                body: JSON.stringify({ type, start, end, note })
+            });
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error(e);
+            return {};
+        }
+    }
+
+    async delete(event) {
         try {
             const response = await fetch(`${this.baseUrl}/remote`, {
                 method: 'DELETE',
                 headers: {
                     'Content-Type': 'application/json',
+                    'If-Match': `"${event.revision || 0}"`,
                     ...this.getAuthHeaders()
                 },
-                body: JSON.stringify({ timestamp: eventTs })
+                body: JSON.stringify({ timestamp: event.timestamp })
             });
             const body = await response.json();
-            return body;
+            // 409 when someone else changed the event, body.current is the
+            // server version
+            return { ...body, status: response.status };
         } catch (e) {
             console.error(e);
             return {};
         }
     }
 
-    async updateEvent(timestamp, newAction) {
+    async updateEvent(event, newAction) {
         try {
             const response = await fetch(`${this.baseUrl}/event/update`, {
                 method: 'PUT',
                 headers: {
                     'Content-Type': 'application/json',
+                    'If-Match': `"${event.revision || 0}"`,
                     ...this.getAuthHeaders()
                 },
-                body: JSON.stringify({ timestamp, new_action: newAction })
+                body: JSON.stringify({ timestamp: event.timestamp, new_action: newAction })
             });
             const body = await response.json();
-            return body;
+            // 409 when someone else changed the event, body.current is the
+            // server version
+            return { ...body, status: response.status };
         } catch (e) {
             console.error(e);
             return {};
@@ -206,9 +433,13 @@ class Api {
         }
     }
 
-    async getAllUsersData() {
+    async getAllUsersData(user, cursor) {
+        const params = new URLSearchParams();
+        if (user) params.set('user', user);
+        if (cursor) params.set('cursor', cursor);
+        params.set('limit', 10);
         try {
-            const response = await fetch(`${this.baseUrl}/admin/data`, {
+            const response = await fetch(`${this.baseUrl}/admin/data?${params}`, {
                 headers: this.getAuthHeaders()
             });
             const body = await response.json();
@@ -310,6 +541,31 @@ class Api {
         }
     }
 
+    // timeZone is an IANA name, e.g. "Europe/Paris"
+    async setTimeZone(timeZone) {
+        try {
+            const response = await fetch(`${this.baseUrl}/me/timezone`, {
+                method: 'PUT',
+                headers: {
+                    ...this.getAuthHeaders(),
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ time_zone: timeZone })
+            });
+
+            if (!response.ok) {
+                const errorData = await response.json();
+                throw new Error(errorData.error || 'Time zone update failed');
+            }
+
+            const body = await response.json();
+            return body;
+        } catch (e) {
+            console.error('Time zone update error:', e);
+            throw e;
+        }
+    }
+
     async deleteAccount(babyName) {
         try {
             const response = await fetch(`${this.baseUrl}/delete-account`, {
@@ -399,6 +655,29 @@ class Api {
         }
     }
 
+    async getWorkload(period) {
+        try {
+            const response = await fetch(`${this.baseUrl}/stats/workload`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...this.getAuthHeaders()
+                },
+                body: JSON.stringify({ period })
+            });
+
+            if (!response.ok) {
+                const errorData = await response.json();
+                throw new Error(errorData.error || 'Workload fetch failed');
+            }
+
+            return await response.json();
+        } catch (e) {
+            console.error('Workload fetch error:', e);
+            throw e;
+        }
+    }
+
     async sendCalendarReport(date, calendarImage = null) {
         try {
             const body = { date };
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -23,15 +27,61 @@ func pong(c *gin.Context) {
 	})
 }
 
+// maxAuthorLength bounds the caregiver name sent in the X-Author header
+const maxAuthorLength = 50
+
 func action(c *gin.Context) {
 	action := c.Param("action")
-	time := time.Now()
+	// The timestamp is optional, in the body or the query, now by default
+	var body struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid json",
+			})
+			return
+		}
+	}
+	if raw := c.Query("timestamp"); raw != "" {
+		timestamp, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid timestamp",
+			})
+			return
+		}
+		body.Timestamp = timestamp
+	}
+	ts := time.Now()
+	if body.Timestamp != 0 {
+		if err := storage.ValidateActionTime(body.Timestamp, ts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		ts = time.UnixMilli(body.Timestamp)
+	}
+
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
-	ok := store.Update(action, time)
+	result, err := store.ApplyAction(action, ts)
+	if err != nil {
+		fmt.Printf("Action %s failed: %v\n", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"action": action,
+			"ok":     false,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"action": action,
-		"ok":     ok,
+		"action":      action,
+		"ok":          true,
+		"events":      result.Events,
+		"revalidated": result.Revalidated,
+		"issues":      result.Issues,
 	})
 }
 
@@ -66,6 +116,7 @@ func AddAction(c *gin.Context) {
 	var action struct {
 		Name string `json:"action"`
 		Time int64  `json:"timestamp"`
+		Note string `json:"note,omitempty"`
 	}
 	err := c.BindJSON(&action)
 	if err != nil {
@@ -76,7 +127,12 @@ func AddAction(c *gin.Context) {
 	}
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
-	ok := store.Save(action.Time, action.Name)
+	_, err = store.SaveEvent(storage.DBBabyEvent{
+		Timestamp: action.Time,
+		Name:      action.Name,
+		Note:      action.Note,
+	})
+	ok := err == nil
 	c.JSON(http.StatusOK, gin.H{
 		"action": action,
 		"ok":     ok,
@@ -87,6 +143,76 @@ type DeleteAction struct {
 	Timestamp int64 `json:"timestamp"`
 }
 
+// ifMatchRevision extracts the event revision from the If-Match header.
+// ETags are the quoted revision number, weak validators are accepted too.
+func ifMatchRevision(c *gin.Context) (int64, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		return 0, false
+	}
+	header = strings.TrimPrefix(header, "W/")
+	header = strings.Trim(header, "\"")
+	revision, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return revision, true
+}
+
+func setEventETag(c *gin.Context, event *storage.DBBabyEvent) {
+	c.Header("ETag", fmt.Sprintf("\"%d\"", event.Revision))
+}
+
+// respondEventWriteError maps the storage errors of a conditional write to
+// HTTP responses. On conflict the current version is returned so the client
+// can merge and retry.
+func respondEventWriteError(c *gin.Context, store *storage.Storage, timestamp int64, current *storage.DBBabyEvent, err error) {
+	switch {
+	case errors.Is(err, storage.ErrEventNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "event not found",
+		})
+	case errors.Is(err, storage.ErrRevisionConflict):
+		if current == nil {
+			current, _ = store.GetEvent(timestamp)
+		}
+		if current != nil {
+			setEventETag(c, current)
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "event was modified by someone else",
+			"current": current,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to write event",
+		})
+	}
+}
+
+func getEvent(c *gin.Context) {
+	timestamp, err := strconv.ParseInt(c.Param("timestamp"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid timestamp",
+		})
+		return
+	}
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	event, err := store.GetEvent(timestamp)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "event not found",
+		})
+		return
+	}
+	setEventETag(c, event)
+	c.JSON(http.StatusOK, gin.H{
+		"event": event,
+	})
+}
+
 func deleteAction(c *gin.Context) {
 	payload := DeleteAction{}
 	err := c.BindJSON(&payload)
@@ -96,13 +222,24 @@ func deleteAction(c *gin.Context) {
 		})
 		return
 	}
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error": "If-Match header required",
+		})
+		return
+	}
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
-	ok := store.Delete(payload.Timestamp)
+	deleted, err := store.Delete(payload.Timestamp, revision)
+	if err != nil {
+		respondEventWriteError(c, store, payload.Timestamp, deleted, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"action":  action,
-		"deleted": ok,
-		"ok":      ok,
+		"timestamp": payload.Timestamp,
+		"deleted":   true,
+		"ok":        true,
 	})
 }
 
@@ -243,6 +380,12 @@ func login(c *gin.Context) {
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		// EventSource can't set headers, the stream takes a single-use
+		// stream token in the URL
+		if authHeader == "" && c.FullPath() == "/api/events/stream" && c.Query("stream_token") != "" {
+			streamTokenAuth(c)
+			return
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Token d'autorisation manquant",
@@ -287,6 +430,32 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// streamTokenAuth authenticates the request with its stream token
+func streamTokenAuth(c *gin.Context) {
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Erreur de connexion à la base de données",
+		})
+		c.Abort()
+		return
+	}
+
+	claims, err := userStorage.ConsumeStreamToken(c.Query("stream_token"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Token invalide",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("user_role", claims.Role)
+	c.Next()
+}
+
 func adminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userRole, exists := c.Get("user_role")
@@ -313,12 +482,24 @@ func changeTimestamp(c *gin.Context) {
 		})
 		return
 	}
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error": "If-Match header required",
+		})
+		return
+	}
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
-	ok := store.ChangeTimestamp(payload.Event, payload.Ts)
+	event, err := store.ChangeTimestamp(payload.Event, payload.Ts, revision)
+	if err != nil {
+		respondEventWriteError(c, store, payload.Event.Timestamp, event, err)
+		return
+	}
+	setEventETag(c, event)
 	c.JSON(http.StatusOK, gin.H{
-		"action":  action,
-		"changed": ok,
+		"changed": true,
+		"event":   event,
 	})
 }
 
@@ -334,14 +515,269 @@ func updateEvent(c *gin.Context) {
 		})
 		return
 	}
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error": "If-Match header required",
+		})
+		return
+	}
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	event, err := store.UpdateEvent(payload.Timestamp, payload.NewAction, revision)
+	if err != nil {
+		respondEventWriteError(c, store, payload.Timestamp, event, err)
+		return
+	}
+	setEventETag(c, event)
+	c.JSON(http.StatusOK, gin.H{
+		"updated": true,
+		"event":   event,
+	})
+}
+
+// importEvents loads a CSV export from another tracker. The file is sent as
+// multipart "file", with either a "preset" name or a JSON "mapping". With
+// dry_run=true the parsed rows are returned without writing anything.
+func importEvents(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "CSV file required",
+		})
+		return
+	}
+
+	mapping, ok := storage.ImportPresets[c.DefaultPostForm("preset", "generic")]
+	if rawMapping := c.PostForm("mapping"); rawMapping != "" {
+		mapping = storage.ImportMapping{}
+		ok = json.Unmarshal([]byte(rawMapping), &mapping) == nil
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid preset or mapping",
+		})
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "failed to read file",
+		})
+		return
+	}
+	defer f.Close()
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	rows, err := storage.ParseImportCSV(f, mapping, store.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	dryRun := c.Query("dry_run") == "true"
+	result := store.Import(rows, dryRun)
+	c.JSON(http.StatusOK, result)
+}
+
+// exportEvents downloads the events of a date range as CSV, NDJSON or XLSX.
+// start and end are unix timestamps in milliseconds, defaulting to the whole
+// history.
+func exportEvents(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := storage.ExportFormats[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "format must be csv, ndjson or xlsx",
+		})
+		return
+	}
+	start, err := strconv.ParseInt(c.DefaultQuery("start", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid start",
+		})
+		return
+	}
+	end, err := strconv.ParseInt(c.DefaultQuery("end", strconv.FormatInt(time.Now().UnixMilli(), 10)), 10, 64)
+	if err != nil || end < start {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid end",
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	rows := store.ExportRows(start, end)
+
+	filename := fmt.Sprintf("babycheck-%s.%s", time.Now().In(store.Location()).Format("2006-01-02"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+	err = storage.WriteExport(c.Writer, format, rows, store.Location())
+	if err != nil {
+		fmt.Printf("Export error: %v\n", err)
+	}
+}
+
+// getSessions returns the sleep and feeding sessions overlapping a range,
+// optionally filtered by type
+func getSessions(c *gin.Context) {
+	start, err := strconv.ParseInt(c.DefaultQuery("start", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid start",
+		})
+		return
+	}
+	end, err := strconv.ParseInt(c.DefaultQuery("end", strconv.FormatInt(time.Now().UnixMilli(), 10)), 10, 64)
+	if err != nil || end < start {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid end",
+		})
+		return
+	}
+	sessionType := c.Query("type")
+	if sessionType != "" && sessionType != "sleep" && sessionType != "leftBoob" && sessionType != "rightBoob" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "type must be sleep, leftBoob or rightBoob",
+		})
+		return
+	}
+
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
-	ok := store.UpdateEvent(payload.Timestamp, payload.NewAction)
+	sessions := store.GetSessions(start, end)
+	if sessionType != "" {
+		filtered := make([]storage.Session, 0, len(sessions))
+		for _, session := range sessions {
+			if session.Type == sessionType {
+				filtered = append(filtered, session)
+			}
+		}
+		sessions = filtered
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"updated": ok,
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// createSession adds a past sleep or feed with its start and end at once
+func createSession(c *gin.Context) {
+	var payload struct {
+		Type  string `json:"type"`
+		Start int64  `json:"start"`
+		End   int64  `json:"end"`
+		Note  string `json:"note,omitempty"`
+	}
+	if err := c.BindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid json",
+		})
+		return
+	}
+	if payload.Type != "sleep" && payload.Type != "leftBoob" && payload.Type != "rightBoob" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "type must be sleep, leftBoob or rightBoob",
+		})
+		return
+	}
+	if payload.Start <= 0 || payload.End <= payload.Start {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "end must be after start",
+		})
+		return
+	}
+	if payload.End > time.Now().Add(storage.MaxActionSkew).UnixMilli() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "session must be over",
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	events, overlapping, err := store.CreateSession(payload.Type, payload.Start, payload.End, payload.Note)
+	if errors.Is(err, storage.ErrSessionOverlap) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       "La session chevauche des événements existants",
+			"overlapping": overlapping,
+		})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to create session: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "could not create session",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"session": storage.Session{
+			Type:     payload.Type,
+			Start:    payload.Start,
+			End:      payload.End,
+			Duration: payload.End - payload.Start,
+		},
+		"events": events,
 	})
 }
 
+// getStatus returns the current state of the baby and the time since the
+// last event of each category
+func getStatus(c *gin.Context) {
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	now := time.Now().UnixMilli()
+	status, err := store.GetStatus(now)
+	if err != nil {
+		fmt.Printf("Status error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "could not compute status",
+		})
+		return
+	}
+	status.Predictions = store.Predict(now)
+	c.JSON(http.StatusOK, status)
+}
+
+// getState returns the state of the baby at the timestamp given in
+// milliseconds or RFC 3339 by the at query parameter, now by default
+func getState(c *gin.Context) {
+	at := time.Now().UnixMilli()
+	if raw := c.Query("at"); raw != "" {
+		ts, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "invalid at",
+				})
+				return
+			}
+			ts = t.UnixMilli()
+		}
+		if ts > time.Now().Add(storage.MaxActionSkew).UnixMilli() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "at is in the future",
+			})
+			return
+		}
+		at = ts
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	c.JSON(http.StatusOK, store.StateAt(at))
+}
+
 func getAllData(c *gin.Context) {
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
@@ -363,9 +799,7 @@ func eraseAllData(c *gin.Context) {
 }
 
 func getStats(c *gin.Context) {
-	body := struct {
-		Period string `json:"period"`
-	}{}
+	body := storage.PeriodRequest{}
 	err := c.BindJSON(&body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -376,46 +810,170 @@ func getStats(c *gin.Context) {
 
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
-	
+
 	// Calculate time range based on period
-	now := time.Now().UnixMilli()
-	var start int64
-	
-	switch body.Period {
-	case "hour":
-		start = now - (60 * 60 * 1000) // 1 hour ago
-	case "day":
-		start = now - (24 * 60 * 60 * 1000) // 24 hours ago
-	case "days2":
-		start = now - (2 * 24 * 60 * 60 * 1000) // 2 days ago
-	case "week":
-		start = now - (7 * 24 * 60 * 60 * 1000) // 7 days ago
-	case "thisweek":
-		// Start of current week (Sunday)
-		currentTime := time.Now()
-		startOfWeek := currentTime.AddDate(0, 0, -int(currentTime.Weekday()))
-		startOfWeek = time.Date(startOfWeek.Year(), startOfWeek.Month(), startOfWeek.Day(), 0, 0, 0, 0, startOfWeek.Location())
-		start = startOfWeek.UnixMilli()
-	default:
-		start = now - (24 * 60 * 60 * 1000) // default to 24 hours
+	period, err := storage.ResolvePeriod(body, time.Now(), store.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
-	stats := store.CalculateStats(start, now)
+	stats := store.CalculatePeriodStats(period, body.Compare)
 	c.JSON(http.StatusOK, stats)
 }
 
-func getAllUsers(c *gin.Context) {
-	userStorage := storage.NewUserStorage()
-	if userStorage == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Erreur de connexion à la base de données",
+// getStatsSeries returns the stats of a period bucketed by hour, day or week,
+// for trend charts
+func getStatsSeries(c *gin.Context) {
+	body := struct {
+		storage.PeriodRequest
+		Bucket string `json:"bucket"`
+	}{}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid json",
 		})
 		return
 	}
 
-	users, err := userStorage.GetAllUsers()
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+
+	period, err := storage.ResolvePeriod(body.PeriodRequest, time.Now(), store.Location())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	bounds, err := storage.BucketBounds(period.Start, period.End, body.Bucket, body.DayStartHour, store.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bucket": body.Bucket,
+		"series": store.CalculateSeries(bounds),
+	})
+}
+
+// getSleepAnalytics returns the longest stretch, night/day split, night
+// wakings and wake windows of a period
+func getSleepAnalytics(c *gin.Context) {
+	body := struct {
+		storage.PeriodRequest
+		NightStartHour *int `json:"night_start_hour"`
+		NightEndHour   *int `json:"night_end_hour"`
+	}{}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid json",
+		})
+		return
+	}
+
+	window := storage.DefaultNightWindow
+	if body.NightStartHour != nil {
+		window.StartHour = *body.NightStartHour
+	}
+	if body.NightEndHour != nil {
+		window.EndHour = *body.NightEndHour
+	}
+	if err := window.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+
+	period, err := storage.ResolvePeriod(body.PeriodRequest, time.Now(), store.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, store.CalculateSleepAnalytics(period.Start, period.End, window))
+}
+
+// getWorkload returns who did the feeds, diaper changes and settlings of a
+// period, the night ones weighted by night_weight
+func getWorkload(c *gin.Context) {
+	body := struct {
+		storage.PeriodRequest
+		NightStartHour *int     `json:"night_start_hour"`
+		NightEndHour   *int     `json:"night_end_hour"`
+		NightWeight    *float64 `json:"night_weight"`
+	}{}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid json",
+		})
+		return
+	}
+
+	window := storage.DefaultNightWindow
+	if body.NightStartHour != nil {
+		window.StartHour = *body.NightStartHour
+	}
+	if body.NightEndHour != nil {
+		window.EndHour = *body.NightEndHour
+	}
+	if err := window.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	nightWeight := storage.DefaultNightWeight
+	if body.NightWeight != nil {
+		nightWeight = *body.NightWeight
+	}
+	if nightWeight < 1 || nightWeight > 10 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "night_weight must be between 1 and 10",
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+
+	period, err := storage.ResolvePeriod(body.PeriodRequest, time.Now(), store.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, store.CalculateWorkload(period.Start, period.End, window, nightWeight))
+}
+
+func getAllUsers(c *gin.Context) {
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Erreur de connexion à la base de données",
+		})
+		return
+	}
+
+	users, err := userStorage.GetAllUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Erreur lors de la récupération des utilisateurs",
 		})
 		return
@@ -430,120 +988,785 @@ func getAllUsers(c *gin.Context) {
 func getRedisStats(c *gin.Context) {
 	stats := storage.GetRedisStats()
 	c.JSON(http.StatusOK, gin.H{
-		"redis": stats,
+		"redis": stats,
+	})
+}
+
+// eventQueryFromRequest reads the pagination and filter query parameters.
+// Types and authors are comma separated lists.
+func eventQueryFromRequest(c *gin.Context) (storage.EventQuery, error) {
+	query := storage.EventQuery{
+		Text:       c.Query("q"),
+		Cursor:     c.Query("cursor"),
+		Descending: c.Query("order") == "desc",
+	}
+	var err error
+	for name, value := range map[string]*int64{"start": &query.Start, "end": &query.End} {
+		if raw := c.Query(name); raw != "" {
+			if *value, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				return query, fmt.Errorf("invalid %s", name)
+			}
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		if query.Limit, err = strconv.Atoi(raw); err != nil || query.Limit < 1 {
+			return query, fmt.Errorf("invalid limit")
+		}
+	}
+	if raw := c.Query("types"); raw != "" {
+		query.Types = strings.Split(raw, ",")
+	}
+	if raw := c.Query("authors"); raw != "" {
+		query.Authors = strings.Split(raw, ",")
+	}
+	return query, nil
+}
+
+// queryEvents returns a page of events, filtered by types, authors and note
+// text. The next page is requested with the returned cursor.
+func queryEvents(c *gin.Context) {
+	query, err := eventQueryFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	page, err := store.Query(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// syncEvents applies a batch of operations recorded offline, in order, and
+// returns the merged server state from the earliest one. Retrying a batch
+// doesn't apply its operations twice.
+func syncEvents(c *gin.Context) {
+	var body struct {
+		Operations []storage.SyncOperation `json:"operations"`
+		Since      int64                   `json:"since"` // Last sync of the client
+	}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid json",
+		})
+		return
+	}
+	if len(body.Operations) > storage.MaxSyncOperations {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("at most %d operations per sync", storage.MaxSyncOperations),
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	results := store.Sync(body.Operations)
+
+	conflicts := make([]storage.SyncResult, 0)
+	for _, result := range results {
+		if result.Conflict != nil {
+			conflicts = append(conflicts, result)
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	from := body.Since
+	for _, operation := range body.Operations {
+		if operation.Timestamp < from || from == 0 {
+			from = operation.Timestamp
+		}
+	}
+	if from == 0 || from > now {
+		from = now - 24*60*60*1000
+	}
+	events := store.Search(from, now)
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":     results,
+		"conflicts":   conflicts,
+		"events":      events,
+		"from":        from,
+		"server_time": now,
+	})
+}
+
+// createStreamToken issues the single-use token opening the event stream
+func createStreamToken(c *gin.Context) {
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Erreur de connexion à la base de données",
+		})
+		return
+	}
+
+	token, err := userStorage.CreateStreamToken(&storage.UserClaims{
+		UserID:   c.GetString("user_id"),
+		Username: c.GetString("username"),
+		Role:     c.GetString("user_role"),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"expires_in": int(storage.StreamTokenTTL.Seconds()),
+	})
+}
+
+// streamEvents pushes the user's event notifications as Server-Sent Events
+// until the client disconnects
+func streamEvents(c *gin.Context) {
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	notifications, err := store.SubscribeEvents(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "could not subscribe to events",
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Disable proxy buffering
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case notification, ok := <-notifications:
+			if !ok {
+				return false
+			}
+			c.SSEvent(notification.Type, notification)
+			return true
+		case <-keepAlive.C:
+			// Comment line, keeps idle connections open
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return true
+		}
+	})
+}
+
+// getAllUsersData returns the latest page of events of every user, newest
+// first, with the cursor of the next page. With user, only that user is
+// returned, from cursor. With format=ndjson, the events of every user are
+// streamed one page at a time instead, so the whole history is never held in
+// memory.
+func getAllUsersData(c *gin.Context) {
+	query, err := eventQueryFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	query.Descending = true
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Erreur de connexion à la base de données",
+		})
+		return
+	}
+
+	var users []*storage.User
+	if username := c.Query("user"); username != "" {
+		user, err := userStorage.GetUser(username)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Utilisateur non trouvé",
+			})
+			return
+		}
+		users = []*storage.User{user}
+	} else {
+		users, err = userStorage.GetAllUsers()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Erreur lors de la récupération des utilisateurs",
+			})
+			return
+		}
+	}
+
+	if c.Query("format") == "ndjson" {
+		streamUsersData(c, users)
+		return
+	}
+
+	allData := make(map[string]interface{})
+	for _, user := range users {
+		if user.Role == "admin" {
+			continue // Skip admin user data
+		}
+
+		userStorage := storage.NewStorage(user.ID)
+		if userStorage == nil {
+			continue
+		}
+		page, err := userStorage.Query(query)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		count, _ := userStorage.Count()
+		allData[user.Username] = map[string]interface{}{
+			"user":        user,
+			"events":      page.Events,
+			"next_cursor": page.NextCursor,
+			"count":       count,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  allData,
+		"users": len(allData),
+	})
+}
+
+// streamUsersData writes the events of the users as NDJSON, oldest first
+func streamUsersData(c *gin.Context, users []*storage.User) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("babycheck-all-%s.ndjson", time.Now().Format("2006-01-02"))))
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for _, user := range users {
+		if user.Role == "admin" {
+			continue // Skip admin user data
+		}
+		store := storage.NewStorage(user.ID)
+		if store == nil {
+			continue
+		}
+
+		query := storage.EventQuery{Limit: storage.MaxQueryLimit}
+		for {
+			page, err := store.Query(query)
+			if err != nil {
+				fmt.Printf("Admin export failed for user %s: %v\n", user.ID, err)
+				break
+			}
+			for _, event := range page.Events {
+				encoder.Encode(struct {
+					User string `json:"user"`
+					storage.DBBabyEvent
+				}{user.Username, event})
+			}
+			c.Writer.Flush()
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+	}
+}
+
+func testEmail(c *gin.Context) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Email required",
+		})
+		return
+	}
+
+	emailService := storage.NewEmailService()
+	if emailService == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Email service not configured",
+		})
+		return
+	}
+
+	err = emailService.SendTestEmail(body.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Email send error: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Test email sent successfully",
+		"email":   body.Email,
+	})
+}
+
+func getCurrentUser(c *gin.Context) {
+	_, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+		})
+		return
+	}
+
+	username, exists := c.Get("username")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Username not found in token",
+		})
+		return
+	}
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	user, err := userStorage.GetUser(username.(string))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": user,
+	})
+}
+
+func setTimeZone(c *gin.Context) {
+	var body struct {
+		TimeZone string `json:"time_zone"`
+	}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Time zone required",
+		})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+		})
+		return
+	}
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	err = userStorage.SetUserTimeZone(userID.(string), body.TimeZone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Fuseau horaire invalide",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Fuseau horaire mis à jour",
+		"time_zone": body.TimeZone,
+	})
+}
+
+func setReminders(c *gin.Context) {
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid json",
+		})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+		})
+		return
+	}
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	err = userStorage.SetUserReminders(userID.(string), body.Enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update reminders",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Rappels mis à jour",
+		"enabled": body.Enabled,
+	})
+}
+
+func setBirthDate(c *gin.Context) {
+	var body struct {
+		BirthDate string `json:"birth_date"`
+	}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Birth date required",
+		})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+		})
+		return
+	}
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	err = userStorage.SetUserBirthDate(userID.(string), body.BirthDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Date de naissance invalide",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Date de naissance mise à jour",
+		"birth_date": body.BirthDate,
+	})
+}
+
+func setAlertEmails(c *gin.Context) {
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid json",
+		})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User authentication required",
+		})
+		return
+	}
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	err = userStorage.SetUserAlertEmails(userID.(string), body.Enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update alert emails",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Alertes mises à jour",
+		"enabled": body.Enabled,
+	})
+}
+
+// getAlerts compares the last complete days with the age-based norms
+func getAlerts(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 1 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "days must be between 1 and 90",
+		})
+		return
+	}
+	dayStartHour, err := strconv.Atoi(c.DefaultQuery("day_start_hour", strconv.Itoa(storage.AlertDayStartHour)))
+	if err != nil || dayStartHour < 0 || dayStartHour > 23 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "day_start_hour must be between 0 and 23",
+		})
+		return
+	}
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+	username, _ := c.Get("username")
+	user, err := userStorage.GetUser(username.(string))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+	if user.BirthDate == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Renseignez la date de naissance pour utiliser les alertes",
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	alerts, err := store.CalculateAlerts(user.BirthDate, days, dayStartHour, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "could not compute alerts",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"birth_date": user.BirthDate,
+		"alerts":     alerts,
+		"count":      len(alerts),
+	})
+}
+
+func checkConsistency(c *gin.Context) {
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	issues := store.CheckConsistency(time.Now().UnixMilli())
+	c.JSON(http.StatusOK, gin.H{
+		"issues": issues,
+		"count":  len(issues),
+	})
+}
+
+// repairEvents applies the fixes chosen among the ones suggested by
+// checkConsistency
+func repairEvents(c *gin.Context) {
+	var payload struct {
+		Repairs []storage.RepairChoice `json:"repairs"`
+	}
+	if err := c.BindJSON(&payload); err != nil || len(payload.Repairs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "repairs required",
+		})
+		return
+	}
+
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	username, _ := c.Get("username")
+	results, issues := store.Repair(payload.Repairs, username.(string), time.Now().UnixMilli())
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"issues":  issues,
+	})
+}
+
+func getAuditLog(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	tmp, _ := c.Get("storage")
+	store := tmp.(*storage.Storage)
+	entries, err := store.GetAuditLog(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "could not read the audit log",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
 	})
 }
 
-func getAllUsersData(c *gin.Context) {
+// runReminders periodically sends the feed and nap reminders and the daily
+// norm alerts to the users who enabled them, and closes the sessions left
+// open for too long
+func runReminders() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		sendReminders()
+		sendNormAlerts()
+		autoCloseSessions()
+	}
+}
+
+// autoCloseSessions closes the sessions left open for too long and emails
+// the users whose sessions were closed
+func autoCloseSessions() {
 	userStorage := storage.NewUserStorage()
 	if userStorage == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Erreur de connexion à la base de données",
-		})
 		return
 	}
-
 	users, err := userStorage.GetAllUsers()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Erreur lors de la récupération des utilisateurs",
-		})
+		fmt.Printf("Auto-close: failed to list users: %v\n", err)
 		return
 	}
 
-	allData := make(map[string]interface{})
+	now := time.Now().UnixMilli()
 	for _, user := range users {
-		if user.Role == "admin" {
-			continue // Skip admin user data
+		store := storage.NewStorage(user.ID)
+		if store == nil {
+			continue
 		}
-		
-		userStorage := storage.NewStorage(user.ID)
-		if userStorage != nil {
-			events := userStorage.GetAllData()
-			allData[user.Username] = map[string]interface{}{
-				"user":   user,
-				"events": events,
-				"count":  len(events),
-			}
+		store.SetLocation(user.Location())
+		closed, err := store.AutoCloseSessions(now)
+		if err != nil {
+			fmt.Printf("Auto-close: failed for user %s: %v\n", user.ID, err)
+		}
+		if len(closed) == 0 || user.Email == "" || !user.EmailVerified {
+			continue
+		}
+		emailService := storage.NewEmailService()
+		if emailService == nil {
+			continue
+		}
+		err = emailService.SendAutoCloseNotice(user.Email, user.Username, closed, user.Location())
+		if err != nil {
+			fmt.Printf("Auto-close: failed to notify user %s: %v\n", user.ID, err)
 		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"data":  allData,
-		"users": len(allData),
-	})
 }
 
-func testEmail(c *gin.Context) {
-	var body struct {
-		Email string `json:"email"`
-	}
-	err := c.BindJSON(&body)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Email required",
-		})
-		return
-	}
+// alertEmailHour is the local hour after which the previous day is checked
+// against the norms. It must not be before storage.AlertDayStartHour, when
+// the previous day is complete.
+const alertEmailHour = 8
 
+// sendNormAlerts emails the users who enabled alert emails when the previous
+// day was outside the norms, once a day
+func sendNormAlerts() {
+	userStorage := storage.NewUserStorage()
 	emailService := storage.NewEmailService()
-	if emailService == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Email service not configured",
-		})
+	if userStorage == nil || emailService == nil {
 		return
 	}
-
-	err = emailService.SendTestEmail(body.Email)
+	users, err := userStorage.GetAllUsers()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Email send error: %v", err),
-		})
+		fmt.Printf("Alerts: failed to list users: %v\n", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Test email sent successfully",
-		"email":   body.Email,
-	})
-}
-
-func getCurrentUser(c *gin.Context) {
-	_, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "User authentication required",
-		})
-		return
-	}
+	for _, user := range users {
+		if !user.AlertEmails || user.BirthDate == "" || user.Email == "" || !user.EmailVerified {
+			continue
+		}
+		now := time.Now().In(user.Location())
+		if now.Hour() < alertEmailHour {
+			continue
+		}
+		first, err := userStorage.MarkReminderSent(user.ID, "alerts", storage.StartOfDay(now, user.Location()).UnixMilli())
+		if err != nil || !first {
+			continue
+		}
 
-	username, exists := c.Get("username")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Username not found in token",
-		})
-		return
+		store := storage.NewStorage(user.ID)
+		if store == nil {
+			continue
+		}
+		store.SetLocation(user.Location())
+		alerts, err := store.CalculateAlerts(user.BirthDate, 1, storage.AlertDayStartHour, now)
+		if err != nil || len(alerts) == 0 {
+			continue
+		}
+		err = emailService.SendNormAlerts(user.Email, user.Username, alerts)
+		if err != nil {
+			fmt.Printf("Alerts: failed to send alerts to user %s: %v\n", user.ID, err)
+		}
 	}
+}
 
+// sendReminders emails the users who enabled reminders shortly before their
+// baby's predicted feed and nap
+func sendReminders() {
 	userStorage := storage.NewUserStorage()
-	if userStorage == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Database connection error",
-		})
+	emailService := storage.NewEmailService()
+	if userStorage == nil || emailService == nil {
 		return
 	}
-
-	user, err := userStorage.GetUser(username.(string))
+	users, err := userStorage.GetAllUsers()
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-		})
+		fmt.Printf("Reminders: failed to list users: %v\n", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"user": user,
-	})
+	now := time.Now()
+	for _, user := range users {
+		if !user.Reminders || user.Email == "" || !user.EmailVerified {
+			continue
+		}
+		store := storage.NewStorage(user.ID)
+		if store == nil {
+			continue
+		}
+		store.SetLocation(user.Location())
+		predictions := store.Predict(now.UnixMilli())
+
+		for kind, prediction := range map[string]*storage.Prediction{
+			"feed": predictions.NextFeed,
+			"nap":  predictions.NextNap,
+		} {
+			if prediction == nil || prediction.Expected < now.UnixMilli() || prediction.Expected > now.Add(storage.ReminderLead).UnixMilli() {
+				continue
+			}
+			first, err := userStorage.MarkReminderSent(user.ID, kind, prediction.Expected)
+			if err != nil || !first {
+				continue
+			}
+			err = emailService.SendPredictionReminder(user.Email, user.Username, kind, prediction, user.Location())
+			if err != nil {
+				fmt.Printf("Reminders: failed to send %s reminder to user %s: %v\n", kind, user.ID, err)
+			}
+		}
+	}
 }
 
 func sendVerificationEmail(c *gin.Context) {
@@ -830,11 +2053,15 @@ func sendCalendarReport(c *gin.Context) {
 	tmp, _ := c.Get("storage")
 	store := tmp.(*storage.Storage)
 	
-	dayStart, _ := time.Parse("2006-01-02", requestBody.Date)
-	dayStartMs := dayStart.UnixMilli()
-	dayEndMs := dayStart.Add(24*time.Hour - time.Millisecond).UnixMilli()
-	
-	events := store.Search(dayStartMs, dayEndMs)
+	// Days are calendar days in the user's time zone
+	events, err := store.SearchDay(requestBody.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Date required in YYYY-MM-DD format",
+		})
+		return
+	}
+	dayStart, _ := time.ParseInLocation("2006-01-02", requestBody.Date, store.Location())
 
 	// Generate email content
 	emailService := storage.NewEmailService()
@@ -846,7 +2073,7 @@ func sendCalendarReport(c *gin.Context) {
 	}
 
 	subject := fmt.Sprintf("Rapport journalier de %s - %s", user.Username, dayStart.Format("02/01/2006"))
-	emailBody := generateCalendarEmailReport(user.Username, requestBody.Date, events)
+	emailBody := generateCalendarEmailReport(user.Username, requestBody.Date, events, store.Location())
 
 	// Send email with image attachment if provided
 	if requestBody.CalendarImage != "" {
@@ -868,7 +2095,7 @@ func sendCalendarReport(c *gin.Context) {
 	})
 }
 
-func generateCalendarEmailReport(babyName, date string, events []storage.DBBabyEvent) string {
+func generateCalendarEmailReport(babyName, date string, events []storage.DBBabyEvent, loc *time.Location) string {
 	// Parse date for formatting
 	dayDate, _ := time.Parse("2006-01-02", date)
 	formattedDate := dayDate.Format("lundi 02 janvier 2006")
@@ -915,8 +2142,8 @@ func generateCalendarEmailReport(babyName, date string, events []storage.DBBabyE
 		</tr>`
 
 	for _, event := range events {
-		eventTime := time.UnixMilli(event.Timestamp).Format("15:04")
-		eventName := getEventDisplayName(event.Name)
+		eventTime := time.UnixMilli(event.Timestamp).In(loc).Format("15:04")
+		eventName := storage.EventDisplayName(event.Name)
 		html += fmt.Sprintf(`
 			<tr>
 				<td style="border: 1px solid #ddd; padding: 8px;">%s</td>
@@ -929,32 +2156,234 @@ func generateCalendarEmailReport(babyName, date string, events []storage.DBBabyE
 	// Footer
 	html += `<hr>
 		<p><small>📧 Rapport généré automatiquement par BabyCheck</small></p>
-		<p><small>🕐 Envoyé le ` + time.Now().Format("02/01/2006 à 15:04") + `</small></p>`
+		<p><small>🕐 Envoyé le ` + time.Now().In(loc).Format("02/01/2006 à 15:04") + `</small></p>`
 
 	return html
 }
 
-func getEventDisplayName(eventName string) string {
-	switch eventName {
-	case "sleep":
-		return "💤 Début du sommeil"
-	case "wake":
-		return "☀️ Réveil"
-	case "leftBoob":
-		return "🍼 Début allaitement (sein gauche)"
-	case "leftBoobStop":
-		return "🍼 Fin allaitement (sein gauche)"
-	case "rightBoob":
-		return "🍼 Début allaitement (sein droit)"
-	case "rightBoobStop":
-		return "🍼 Fin allaitement (sein droit)"
-	case "pee":
-		return "💧 Pipi"
-	case "poop":
-		return "💩 Caca"
-	default:
-		return eventName
+func calendarFeedURL(token string) string {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080" // Default for development
+	}
+	return fmt.Sprintf("%s/api/calendar/%s.ics", baseURL, token)
+}
+
+func getCalendarFeed(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	token, err := userStorage.GetCalendarToken(userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get calendar token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": calendarFeedURL(token),
+	})
+}
+
+func rotateCalendarFeed(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	token, err := userStorage.RotateCalendarToken(userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to rotate calendar token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": calendarFeedURL(token),
+	})
+}
+
+// calendarFeed serves the iCalendar subscription. Calendar apps cannot send
+// a JWT, so the secret token in the URL is the only credential.
+func calendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.String(http.StatusInternalServerError, "Database connection error")
+		return
+	}
+	userID, err := userStorage.GetUserIDByCalendarToken(token)
+	if err != nil {
+		c.String(http.StatusNotFound, "Calendar not found")
+		return
+	}
+	user, err := userStorage.GetUserByID(userID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Calendar not found")
+		return
+	}
+	store := storage.NewStorage(userID)
+	if store == nil {
+		c.String(http.StatusInternalServerError, "Storage connection error")
+		return
+	}
+
+	// Past 60 days of sessions, and upcoming appointments
+	now := time.Now()
+	events := store.Search(now.AddDate(0, 0, -60).UnixMilli(), now.AddDate(1, 0, 0).UnixMilli())
+
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
+	c.Status(http.StatusOK)
+	err = storage.WriteICS(c.Writer, user.Username, events, now.UnixMilli())
+	if err != nil {
+		fmt.Printf("Calendar feed error: %v\n", err)
+	}
+}
+
+// requestDataArchive starts a background job bundling all the user's data.
+// The download link is sent by email once the archive is ready.
+func requestDataArchive(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	username, _ := c.Get("username")
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	user, err := userStorage.GetUser(username.(string))
+	if err != nil || user.Email == "" || !user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Email address not verified. Please verify your email first.",
+		})
+		return
+	}
+
+	if status, err := userStorage.GetArchiveStatus(userID.(string)); err == nil && status.Running(time.Now()) {
+		c.JSON(http.StatusAccepted, status)
+		return
+	}
+
+	status := &storage.ArchiveStatus{
+		Status:    "pending",
+		Requested: time.Now().UnixMilli(),
+	}
+	if err := userStorage.SetArchiveStatus(userID.(string), status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start export",
+		})
+		return
 	}
+
+	go buildDataArchive(user, status)
+
+	c.JSON(http.StatusAccepted, status)
+}
+
+func buildDataArchive(user *storage.User, status *storage.ArchiveStatus) {
+	userStorage := storage.NewUserStorage()
+	store := storage.NewStorage(user.ID)
+	emailService := storage.NewEmailService()
+	if userStorage == nil {
+		fmt.Printf("Data archive for user %s failed: no storage\n", user.ID)
+		return
+	}
+
+	fail := func(err error) {
+		fmt.Printf("Data archive for user %s failed: %v\n", user.ID, err)
+		status.Status = "error"
+		status.Error = err.Error()
+		userStorage.SetArchiveStatus(user.ID, status)
+	}
+	if store == nil {
+		fail(fmt.Errorf("no storage"))
+		return
+	}
+	if emailService == nil {
+		fail(fmt.Errorf("email service not configured"))
+		return
+	}
+
+	audit, err := store.GetAuditLog(0)
+	if err != nil {
+		fail(err)
+		return
+	}
+	archive, err := storage.BuildDataArchive(user, store.GetAllData(), audit, user.Location())
+	if err != nil {
+		fail(err)
+		return
+	}
+	token, err := userStorage.StoreDataArchive(archive)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := emailService.SendDataArchiveLink(user.Email, token); err != nil {
+		fail(err)
+		return
+	}
+
+	status.Status = "ready"
+	status.ExpiresAt = time.Now().Add(24 * time.Hour).UnixMilli()
+	userStorage.SetArchiveStatus(user.ID, status)
+	fmt.Printf("Data archive for user %s sent to %s (%d bytes)\n", user.ID, user.Email, len(archive))
+}
+
+func getDataArchiveStatus(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Database connection error",
+		})
+		return
+	}
+
+	status, err := userStorage.GetArchiveStatus(userID.(string))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "No export requested",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// downloadDataArchive serves an archive from the time-limited link sent by
+// email
+func downloadDataArchive(c *gin.Context) {
+	userStorage := storage.NewUserStorage()
+	if userStorage == nil {
+		c.String(http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	archive, err := userStorage.GetDataArchive(c.Param("token"))
+	if err != nil {
+		c.String(http.StatusNotFound, "Lien expiré ou invalide")
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\"babycheck-mes-donnees.zip\"")
+	c.Data(http.StatusOK, "application/zip", archive)
 }
 
 func resetPassword(c *gin.Context) {
@@ -1007,6 +2436,15 @@ func resetPassword(c *gin.Context) {
 }
 
 func main() {
+	if err := storage.LoadMaxSessionDurations(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Admin commands run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	port := os.Getenv("PORT")
 
 	if port == "" {
@@ -1027,7 +2465,8 @@ func main() {
 			fmt.Println("CORS middleware processing")
 			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match, X-Author")
+			c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
 			// return 200 for options
 			if c.Request.Method == "OPTIONS" {
 				c.JSON(http.StatusOK, gin.H{})
@@ -1051,6 +2490,8 @@ func main() {
 	router.POST("/api/request-password-reset", requestPasswordReset)
 	router.POST("/api/reset-password", resetPassword)
 	router.GET("/api/ping", pong)
+	router.GET("/api/calendar/:token", calendarFeed)
+	router.GET("/api/archive/:token", downloadDataArchive)
 
 	// Protected endpoints (require authentication)
 	api := router.Group("/api")
@@ -1075,20 +2516,50 @@ func main() {
 				c.Abort()
 				return
 			}
+			// Day boundaries follow the user's time zone
+			if users := storage.NewUserStorage(); users != nil {
+				userStorage.SetLocation(users.UserLocation(userID.(string)))
+			}
+			// Caregiver tapping on this device, recorded as author of the events
+			if author := strings.TrimSpace(c.GetHeader("X-Author")); author != "" && len(author) <= maxAuthorLength {
+				userStorage.SetAuthor(author)
+			}
 			c.Set("storage", userStorage)
 			c.Next()
 		})
 		
 		api.POST("/search", search)
 		api.POST("/stats", getStats)
+		api.POST("/stats/series", getStatsSeries)
+		api.POST("/stats/sleep", getSleepAnalytics)
+		api.POST("/stats/workload", getWorkload)
 		api.POST("/remote/:action", action)
 		api.POST("/remote/update", changeTimestamp)
 		api.PUT("/event/update", updateEvent)
+		api.GET("/event/:timestamp", getEvent)
 		api.POST("/add", AddAction)
+		api.POST("/import", importEvents)
+		api.GET("/export", exportEvents)
+		api.GET("/sessions", getSessions)
+		api.POST("/sessions", createSession)
+		api.GET("/status", getStatus)
+		api.GET("/state", getState)
+		api.GET("/events", queryEvents)
+		api.GET("/events/stream", streamEvents)
+		api.POST("/events/stream-token", createStreamToken)
+		api.POST("/sync", syncEvents)
 		api.DELETE("/remote", deleteAction)
 		api.GET("/mode", getMode)
 		api.GET("/redis-stats", getRedisStats)
 		api.GET("/me", getCurrentUser)
+		api.PUT("/me/timezone", setTimeZone)
+		api.PUT("/me/reminders", setReminders)
+		api.PUT("/me/birth-date", setBirthDate)
+		api.PUT("/me/alerts", setAlertEmails)
+		api.GET("/alerts", getAlerts)
+		api.GET("/consistency", checkConsistency)
+		api.POST("/consistency/repair", repairEvents)
+		api.GET("/audit", getAuditLog)
 		
 		// Email verification endpoints
 		api.POST("/send-verification-email", sendVerificationEmail)
@@ -1096,9 +2567,15 @@ func main() {
 		
 		// Account management
 		api.DELETE("/delete-account", deleteAccount)
+		api.POST("/me/archive", requestDataArchive)
+		api.GET("/me/archive", getDataArchiveStatus)
 		
 		// Calendar report
 		api.POST("/send-calendar-report", sendCalendarReport)
+
+		// Calendar subscription
+		api.GET("/calendar-feed", getCalendarFeed)
+		api.POST("/calendar-feed/rotate", rotateCalendarFeed)
 	}
 	
 	// Admin endpoints (require admin role)
@@ -1141,6 +2618,9 @@ func main() {
 		Handler: router,
 	}
 
+	// Rappels de tétée et de sieste, alertes quotidiennes
+	go runReminders()
+
 	// Démarrage du serveur en arrière-plan
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/heroku/babycheck/storage"
+)
+
+func newTestContext(method, body string, headers map[string]string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		c.Request.Header.Set(name, value)
+	}
+	return c, w
+}
+
+func TestIfMatchRevision(t *testing.T) {
+	cases := []struct {
+		header   string
+		revision int64
+		ok       bool
+	}{
+		{"", 0, false},
+		{`"3"`, 3, true},
+		{"3", 3, true},
+		{`W/"3"`, 3, true},
+		{`"abc"`, 0, false},
+		{"*", 0, false},
+	}
+	for _, tc := range cases {
+		c, _ := newTestContext(http.MethodPut, "", map[string]string{"If-Match": tc.header})
+		revision, ok := ifMatchRevision(c)
+		if revision != tc.revision || ok != tc.ok {
+			t.Errorf("If-Match %q: expected (%d, %v), got (%d, %v)", tc.header, tc.revision, tc.ok, revision, ok)
+		}
+	}
+}
+
+func TestETagRoundTrip(t *testing.T) {
+	c, w := newTestContext(http.MethodGet, "", nil)
+	setEventETag(c, &storage.DBBabyEvent{Revision: 7})
+
+	etag := w.Header().Get("ETag")
+	next, _ := newTestContext(http.MethodPut, "", map[string]string{"If-Match": etag})
+	if revision, ok := ifMatchRevision(next); !ok || revision != 7 {
+		t.Errorf("Expected the ETag %q to round-trip to revision 7, got (%d, %v)", etag, revision, ok)
+	}
+}
+
+func TestWriteWithoutIfMatch(t *testing.T) {
+	for name, handler := range map[string]gin.HandlerFunc{"updateEvent": updateEvent, "deleteAction": deleteAction} {
+		for _, header := range []string{"", "not-a-revision"} {
+			c, w := newTestContext(http.MethodPut, `{"timestamp": 1000000000000, "new_action": "wake"}`, map[string]string{"If-Match": header})
+			handler(c)
+			if w.Code != http.StatusPreconditionRequired {
+				t.Errorf("%s with If-Match %q: expected 428, got %d", name, header, w.Code)
+			}
+		}
+	}
+}
+
+func TestRespondEventWriteErrorConflict(t *testing.T) {
+	c, w := newTestContext(http.MethodPut, "", nil)
+	current := &storage.DBBabyEvent{ID: "1", Timestamp: 1000000000000, Name: "wake", Revision: 2}
+	respondEventWriteError(c, nil, current.Timestamp, current, storage.ErrRevisionConflict)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected 409, got %d", w.Code)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Errorf("Expected the ETag of the current revision, got %q", etag)
+	}
+	var body struct {
+		Current storage.DBBabyEvent `json:"current"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Current != *current {
+		t.Errorf("Expected the current event in the body, got %s", w.Body.String())
+	}
+}
+
+func TestRespondEventWriteErrorNotFound(t *testing.T) {
+	c, w := newTestContext(http.MethodDelete, "", nil)
+	respondEventWriteError(c, nil, 1000000000000, nil, storage.ErrEventNotFound)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
+
+func TestUpdateEventStaleRevision(t *testing.T) {
+	if os.Getenv("SCALINGO_REDIS_URL") == "" {
+		t.Skip("SCALINGO_REDIS_URL not set")
+	}
+	store := storage.NewStorage("test-" + uuid.New().String())
+	if store == nil {
+		t.Skip("Redis unavailable")
+	}
+	timestamp := int64(1000000000000)
+	saved, err := store.SaveEvent(storage.DBBabyEvent{Timestamp: timestamp, Name: "sleep"})
+	if err != nil {
+		t.Fatalf("SaveEvent failed: %v", err)
+	}
+	defer store.EraseAll()
+
+	update := func(revision int64) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"timestamp": %d, "new_action": "wake"}`, timestamp)
+		c, w := newTestContext(http.MethodPut, body, map[string]string{"If-Match": fmt.Sprintf(`"%d"`, revision)})
+		c.Set("storage", store)
+		updateEvent(c)
+		return w
+	}
+
+	w := update(saved.Revision)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != fmt.Sprintf(`"%d"`, saved.Revision+1) {
+		t.Fatalf("Expected 200 with the next revision as ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	// Same If-Match again, the event has moved on
+	w = update(saved.Revision)
+	if w.Code != http.StatusConflict || w.Header().Get("ETag") != fmt.Sprintf(`"%d"`, saved.Revision+1) {
+		t.Errorf("Expected 409 with the current ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestCheckCommandRepair(t *testing.T) {
+	if os.Getenv("SCALINGO_REDIS_URL") == "" {
+		t.Skip("SCALINGO_REDIS_URL not set")
+	}
+	users := storage.NewUserStorage()
+	if users == nil {
+		t.Skip("Redis unavailable")
+	}
+	username := "test-" + uuid.New().String()
+	user, err := users.CreateUser(username, "password")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	defer users.DeleteUserAccount(user.ID)
+	store := storage.NewStorage(user.ID)
+
+	timestamp := int64(1000000000000)
+	orphan, err := store.SaveEvent(storage.DBBabyEvent{Timestamp: timestamp, Name: "wake"})
+	if err != nil {
+		t.Fatalf("SaveEvent failed: %v", err)
+	}
+	if _, err := store.SaveEvent(storage.DBBabyEvent{Timestamp: timestamp + 60*1000, Name: "pee"}); err != nil {
+		t.Fatalf("SaveEvent failed: %v", err)
+	}
+	issueID := fmt.Sprintf("orphan_stop:%d:%s", timestamp, orphan.ID)
+
+	if code := checkCommand([]string{"-repair", issueID + "=delete", username}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	events := store.Search(timestamp-1, timestamp+2*60*1000)
+	if len(events) != 1 || events[0].Name != "pee" {
+		t.Errorf("Expected only the pee left, got %+v", events)
+	}
+	entries, err := store.GetAuditLog(10)
+	if err != nil {
+		t.Fatalf("GetAuditLog failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected one audit entry, got %+v", entries)
+	}
+	entry := entries[0]
+	if entry.Actor != "cli" || entry.Action != "repair" || entry.IssueID != issueID || entry.Fix != "delete" ||
+		entry.Before == nil || entry.Before.ID != orphan.ID || entry.After != nil {
+		t.Errorf("Expected the delete of the orphan wake audited, got %+v", entry)
+	}
+}
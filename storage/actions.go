@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// MaxActionSkew is how far in the future an action timestamp may be, to
+	// allow for clock differences
+	MaxActionSkew = time.Minute
+	// MaxActionAge is how far back an action may be backdated, queued
+	// offline operations included
+	MaxActionAge = 30 * 24 * time.Hour
+)
+
+// ValidateActionTime checks that the timestamp of a new event, in
+// milliseconds, is neither in the future nor older than MaxActionAge
+func ValidateActionTime(timestamp int64, now time.Time) error {
+	if timestamp > now.Add(MaxActionSkew).UnixMilli() {
+		return fmt.Errorf("timestamp is in the future")
+	}
+	if timestamp < now.Add(-MaxActionAge).UnixMilli() {
+		return fmt.Errorf("timestamp is older than %d days", int(MaxActionAge.Hours()/24))
+	}
+	return nil
+}
+
+// ActionResult is the outcome of a remote action
+type ActionResult struct {
+	Events      []DBBabyEvent `json:"events"`                // Events written, the action one last
+	Revalidated []DBBabyEvent `json:"revalidated,omitempty"` // Later events whose toggle changed
+	Issues      []Issue       `json:"issues,omitempty"`      // Invalid sequences the events created, left for the parents to repair
+}
+
+// resolveAction applies the toggle rules to a remote action given the
+// sessions going on: a second sleep is a wake, a second feed start on the
+// same side its stop, and a diaper change or a feed during a sleep needs a
+// wake first
+func resolveAction(action string, state sessionState) (string, bool) {
+	switch action {
+	case "sleep":
+		if state.SleepStart != 0 {
+			return "wake", false
+		}
+	case "leftBoob":
+		if state.LeftStart != 0 {
+			return "leftBoobStop", false
+		}
+		return action, state.SleepStart != 0
+	case "rightBoob":
+		if state.RightStart != 0 {
+			return "rightBoobStop", false
+		}
+		return action, state.SleepStart != 0
+	case "pee", "poop":
+		return action, state.SleepStart != 0
+	}
+	return action, false
+}
+
+// toggleOf returns the remote action which resolves to the event name, or
+// "" when the event isn't a toggle
+func toggleOf(name string) string {
+	switch name {
+	case "sleep", "wake":
+		return "sleep"
+	case "leftBoob", "leftBoobStop":
+		return "leftBoob"
+	case "rightBoob", "rightBoobStop":
+		return "rightBoob"
+	}
+	return ""
+}
+
+// stepState returns the sessions going on after event
+func stepState(state sessionState, event DBBabyEvent) sessionState {
+	_, next := pairSessions(state, []DBBabyEvent{event}, event.Timestamp)
+	return next
+}
+
+// ApplyAction runs a remote action at ts through the state machine. The
+// toggle is resolved against the sessions going on just before ts, so the
+// action can be backdated. A later toggle whose pairing the inserted events
+// broke is then resolved again, see revalidations. The events are read and
+// written in one WATCH/MULTI transaction, so concurrent taps can't both
+// resolve against the same state.
+func (s *Storage) ApplyAction(action string, ts time.Time) (*ActionResult, error) {
+	timestamp := ts.UnixMilli()
+	var plan *ActionResult
+	txf := func(tx *redis.Tx) error {
+		earlier := s.Search(timestamp-statsLookback, timestamp-1)
+		laterMembers, err := tx.ZRangeByScore(s.ctx, s.eventsKey(), &redis.ZRangeBy{
+			Min: fmt.Sprintf("%d", timestamp+1),
+			Max: fmt.Sprintf("%d", timestamp+statsLookback),
+		}).Result()
+		if err != nil {
+			return err
+		}
+		later := make([]DBBabyEvent, 0, len(laterMembers))
+		members := make(map[string]string, len(laterMembers))
+		for _, member := range laterMembers {
+			var event DBBabyEvent
+			if json.Unmarshal([]byte(member), &event) == nil {
+				later = append(later, event)
+				members[event.ID] = member
+			}
+		}
+
+		plan = planAction(action, timestamp, earlier, later, s.eventAuthor(), time.Now().UnixMilli())
+		inserted := make([]redis.Z, 0, len(plan.Events))
+		for i := range plan.Events {
+			member, err := plan.Events[i].Json()
+			if err != nil {
+				return err
+			}
+			inserted = append(inserted, redis.Z{Score: float64(plan.Events[i].Timestamp), Member: member})
+		}
+		renamed := make([]redis.Z, 0, len(plan.Revalidated))
+		for i := range plan.Revalidated {
+			plan.Revalidated[i].Revision++
+			member, err := plan.Revalidated[i].Json()
+			if err != nil {
+				return err
+			}
+			renamed = append(renamed, redis.Z{Score: float64(plan.Revalidated[i].Timestamp), Member: member})
+		}
+
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(s.ctx, s.eventsKey(), inserted...)
+			for i, event := range plan.Revalidated {
+				pipe.ZRem(s.ctx, s.eventsKey(), members[event.ID])
+				pipe.ZAdd(s.ctx, s.eventsKey(), renamed[i])
+			}
+			return nil
+		})
+		return err
+	}
+
+	// Retry when another write happened between the read and the write
+	for i := 0; i < 3; i++ {
+		err := s.redis.Watch(s.ctx, txf, s.eventsKey())
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		applied := plan.Events[len(plan.Events)-1].Name
+		fmt.Printf("Action %s applied as %s at %d\n", action, applied, timestamp)
+		timestamps := []int64{timestamp}
+		for _, event := range plan.Revalidated {
+			timestamps = append(timestamps, event.Timestamp)
+		}
+		s.invalidateAggregates(timestamps...)
+		for i := range plan.Events {
+			s.publish("created", &plan.Events[i], plan.Events[i].Timestamp)
+		}
+		for i := range plan.Revalidated {
+			s.publish("updated", &plan.Revalidated[i], plan.Revalidated[i].Timestamp)
+		}
+		return plan, nil
+	}
+	return nil, redis.TxFailedErr
+}
+
+// planAction computes the events an action at timestamp inserts, given the
+// events of the statsLookback before and after it, the later events to
+// rename and the issues left in the later events
+func planAction(action string, timestamp int64, earlier, later []DBBabyEvent, author string, now int64) *ActionResult {
+	_, before := pairSessions(sessionState{}, earlier, timestamp)
+	resolved, addWake := resolveAction(action, before)
+
+	events := make([]DBBabyEvent, 0, 2)
+	if addWake {
+		events = append(events, DBBabyEvent{
+			ID:        uuid.New().String(),
+			Timestamp: timestamp - 1, // Just before the action
+			Name:      "wake",
+			Author:    author,
+			Revision:  1,
+			Source:    SourceInferred,
+		})
+	}
+	events = append(events, DBBabyEvent{
+		ID:        uuid.New().String(),
+		Timestamp: timestamp,
+		Name:      resolved,
+		Author:    author,
+		Revision:  1,
+		Source:    SourceRecorded,
+	})
+
+	after := before
+	for _, event := range events {
+		after = stepState(after, event)
+	}
+	renamed := revalidations(later, before, after)
+	return &ActionResult{
+		Events:      events,
+		Revalidated: renamed,
+		Issues:      newIssues(earlier, events, later, renamed, now),
+	}
+}
+
+// newIssues returns the issues CheckEvents finds once the events are
+// inserted and the later events renamed, which it didn't find before. A
+// backdated action can leave a later stop without start, or make a later
+// start a repeat, that revalidations doesn't rewrite.
+func newIssues(earlier, inserted, later, renamed []DBBabyEvent, now int64) []Issue {
+	before := append(append([]DBBabyEvent{}, earlier...), later...)
+	known := make(map[string]bool)
+	for _, issue := range CheckEvents(before, now) {
+		known[issue.ID] = true
+	}
+
+	names := make(map[string]string, len(renamed))
+	for _, event := range renamed {
+		names[event.ID] = event.Name
+	}
+	after := append(append([]DBBabyEvent{}, earlier...), inserted...)
+	for _, event := range later {
+		if name, ok := names[event.ID]; ok {
+			event.Name = name
+		}
+		after = append(after, event)
+	}
+	sort.SliceStable(after, func(i, j int) bool { return after[i].Timestamp < after[j].Timestamp })
+
+	issues := make([]Issue, 0)
+	for _, issue := range CheckEvents(after, now) {
+		if !known[issue.ID] {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// revalidations replays the events following an insert from the state
+// without the inserted events (before) and with them (after), until both are
+// the same. Only the first later toggle of each session type the insert
+// changed is considered: when the insert left a session open that wasn't,
+// a start tapped as a toggle was meant as its stop and is returned renamed.
+// A recorded stop or wake is never turned into a start, it ends the
+// revalidation of its type.
+func revalidations(events []DBBabyEvent, before, after sessionState) []DBBabyEvent {
+	renamed := make([]DBBabyEvent, 0)
+	done := make(map[string]bool)
+	for _, event := range events {
+		if before == after {
+			break
+		}
+		original := event
+		kind := toggleOf(event.Name)
+		if kind != "" && !done[kind] && openSince(before, kind) != openSince(after, kind) {
+			done[kind] = true
+			if event.Name == kind && openSince(before, kind) == 0 && openSince(after, kind) != 0 {
+				event.Name = sessionStops[kind]
+				renamed = append(renamed, event)
+			}
+		}
+		before = stepState(before, original)
+		after = stepState(after, event)
+	}
+	return renamed
+}
+
+// openSince returns the start of the ongoing session of the type, 0 when
+// none is going on
+func openSince(state sessionState, kind string) int64 {
+	switch kind {
+	case "sleep":
+		return state.SleepStart
+	case "leftBoob":
+		return state.LeftStart
+	case "rightBoob":
+		return state.RightStart
+	}
+	return 0
+}
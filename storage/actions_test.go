@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveAction(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   string
+		state    sessionState
+		expected string
+		addWake  bool
+	}{
+		{"Sleep when awake", "sleep", sessionState{}, "sleep", false},
+		{"Sleep when sleeping", "sleep", sessionState{SleepStart: 1}, "wake", false},
+		{"Feed start", "leftBoob", sessionState{}, "leftBoob", false},
+		{"Feed stop", "leftBoob", sessionState{LeftStart: 1}, "leftBoobStop", false},
+		{"Other side during a feed", "rightBoob", sessionState{LeftStart: 1}, "rightBoob", false},
+		{"Feed during a sleep", "rightBoob", sessionState{SleepStart: 1}, "rightBoob", true},
+		{"Diaper during a sleep", "poop", sessionState{SleepStart: 1}, "poop", true},
+		{"Diaper when awake", "pee", sessionState{}, "pee", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, addWake := resolveAction(tt.action, tt.state)
+			if name != tt.expected || addWake != tt.addWake {
+				t.Errorf("Expected %s (wake %v), got %s (wake %v)", tt.expected, tt.addWake, name, addWake)
+			}
+			if toggle := toggleOf(name); toggle != "" && toggle != tt.action {
+				t.Errorf("Expected %s to toggle back to %s, got %s", name, tt.action, toggle)
+			}
+		})
+	}
+}
+
+// insertAction returns the states without and with a backdated action, as
+// ApplyAction computes them
+func insertAction(action string, ts int64, before sessionState) (sessionState, sessionState) {
+	resolved, addWake := resolveAction(action, before)
+	after := before
+	if addWake {
+		after = stepState(after, DBBabyEvent{Timestamp: ts - 1, Name: "wake"})
+	}
+	return before, stepState(after, DBBabyEvent{Timestamp: ts, Name: resolved})
+}
+
+func TestRevalidations(t *testing.T) {
+	baseTime := int64(1000000000000)
+	hour := int64(60 * 60 * 1000)
+	minute := int64(60 * 1000)
+
+	t.Run("Diaper change backdated during a nap", func(t *testing.T) {
+		later := []DBBabyEvent{
+			{Timestamp: baseTime + 2*hour, Name: "wake"},
+			{Timestamp: baseTime + 3*hour, Name: "sleep"},
+			{Timestamp: baseTime + 4*hour, Name: "wake"},
+		}
+		before, after := insertAction("pee", baseTime+hour, sessionState{SleepStart: baseTime})
+		if renamed := revalidations(later, before, after); len(renamed) != 0 {
+			t.Errorf("Expected the recorded wakes and sleeps untouched, got %+v", renamed)
+		}
+	})
+
+	t.Run("Feed start backdated during a feed", func(t *testing.T) {
+		later := []DBBabyEvent{
+			{Timestamp: baseTime + 20*minute, Name: "leftBoobStop"},
+			{Timestamp: baseTime + 2*hour, Name: "leftBoob"},
+		}
+		before, after := insertAction("leftBoob", baseTime+10*minute, sessionState{LeftStart: baseTime})
+		if renamed := revalidations(later, before, after); len(renamed) != 0 {
+			t.Errorf("Expected the recorded feed stop untouched, got %+v", renamed)
+		}
+	})
+
+	t.Run("Sleep backdated before a sleep tap meant as wake", func(t *testing.T) {
+		later := []DBBabyEvent{
+			{Timestamp: baseTime + 2*hour, Name: "sleep"},
+			{Timestamp: baseTime + 3*hour, Name: "sleep"},
+			{Timestamp: baseTime + 4*hour, Name: "wake"},
+		}
+		before, after := insertAction("sleep", baseTime, sessionState{})
+		renamed := revalidations(later, before, after)
+		if len(renamed) != 1 || renamed[0].Timestamp != baseTime+2*hour || renamed[0].Name != "wake" {
+			t.Errorf("Expected only the first sleep renamed to wake, got %+v", renamed)
+		}
+	})
+}
+
+func TestPlanAction(t *testing.T) {
+	baseTime := int64(1000000000000)
+	hour := int64(60 * 60 * 1000)
+	minute := int64(60 * 1000)
+
+	// Both later events are affected by the backdated feed start: the start
+	// tapped as a toggle becomes its stop, which leaves the recorded stop
+	// without start
+	later := []DBBabyEvent{
+		{ID: "start", Timestamp: baseTime + hour, Name: "leftBoob", Revision: 1},
+		{ID: "stop", Timestamp: baseTime + hour + 30*minute, Name: "leftBoobStop", Revision: 1},
+	}
+	plan := planAction("leftBoob", baseTime, nil, later, "None", baseTime+2*hour)
+
+	if len(plan.Events) != 1 || plan.Events[0].Name != "leftBoob" || plan.Events[0].Timestamp != baseTime {
+		t.Errorf("Expected a feed start at the action time, got %+v", plan.Events)
+	}
+	if len(plan.Revalidated) != 1 || plan.Revalidated[0].ID != "start" || plan.Revalidated[0].Name != "leftBoobStop" {
+		t.Errorf("Expected the later start renamed to a stop, got %+v", plan.Revalidated)
+	}
+	if len(plan.Issues) != 1 || plan.Issues[0].Kind != "orphan_stop" || plan.Issues[0].Event.ID != "stop" {
+		t.Errorf("Expected the recorded stop reported as orphan, got %+v", plan.Issues)
+	}
+
+	// Issues already there before the action aren't reported again
+	later = append(later, DBBabyEvent{ID: "orphan", Timestamp: baseTime + 3*hour, Name: "wake", Revision: 1})
+	plan = planAction("leftBoob", baseTime, nil, later, "None", baseTime+4*hour)
+	if len(plan.Issues) != 1 || plan.Issues[0].Event.ID != "stop" {
+		t.Errorf("Expected only the new orphan stop, got %+v", plan.Issues)
+	}
+}
+
+func TestValidateActionTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		timestamp int64
+		valid     bool
+	}{
+		{"Now", now.UnixMilli(), true},
+		{"Backdated a day", now.Add(-24 * time.Hour).UnixMilli(), true},
+		{"Clock skew", now.Add(30 * time.Second).UnixMilli(), true},
+		{"Future", now.Add(time.Hour).UnixMilli(), false},
+		{"Zero", 0, false},
+		{"Too old", now.Add(-MaxActionAge - time.Hour).UnixMilli(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateActionTime(tt.timestamp, now); (err == nil) != tt.valid {
+				t.Errorf("Expected valid %v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
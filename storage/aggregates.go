@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// dailyAggregate is the materialized stats of one local day. Sessions are
+// only known until the end of the day, so a sleep still going on at midnight
+// is counted as completed by mergeAggregates when a later day of the range
+// carries its end.
+type dailyAggregate struct {
+	Location           string       `json:"location"` // Time zone of the day boundaries
+	Stats              BabyStats    `json:"stats"`
+	CompletedSleepTime int64        `json:"completed_sleep_time"` // Full duration of the sleeps started and ended within the day
+	FeedStarts         []int64      `json:"feed_starts"`
+	CarriedSleep       *Session     `json:"carried_sleep,omitempty"` // Sleep going on at the start of the day
+	EndState           sessionState `json:"end_state"`               // Sessions going on at the end of the day
+}
+
+// aggregateInvalidationMargin is how far around a modified event the daily
+// aggregates are invalidated. An event changes the sessions open at the
+// start of the following days up to statsLookback, and the sessions it
+// closes may have started up to statsLookback before. The extra day covers
+// day boundaries in any time zone.
+const aggregateInvalidationMargin = statsLookback + 24*60*60*1000
+
+// aggregatesKey returns the hash holding the daily aggregates, by local date
+func (s *Storage) aggregatesKey() string {
+	return s.eventsKey() + ":daily"
+}
+
+// aggregatesVersionKey returns the counter incremented on every write, so an
+// aggregate computed before a write is never stored after it
+func (s *Storage) aggregatesVersionKey() string {
+	return s.eventsKey() + ":daily_version"
+}
+
+// invalidateAggregates drops the daily aggregates which may depend on events
+// at the given timestamps
+func (s *Storage) invalidateAggregates(timestamps ...int64) {
+	fields := make([]string, 0)
+	for _, ts := range timestamps {
+		fields = append(fields, invalidatedDays(ts, s.loc)...)
+	}
+	_, err := s.redis.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(s.ctx, s.aggregatesKey(), fields...)
+		pipe.Incr(s.ctx, s.aggregatesVersionKey())
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Failed to invalidate daily aggregates: %v\n", err)
+	}
+}
+
+// invalidatedDays returns the local dates of the aggregates which may depend
+// on an event at ts
+func invalidatedDays(ts int64, loc *time.Location) []string {
+	days := make([]string, 0)
+	last := time.UnixMilli(ts + aggregateInvalidationMargin).In(loc)
+	for day := StartOfDay(time.UnixMilli(ts-aggregateInvalidationMargin), loc); !day.After(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	return days
+}
+
+// dropAggregates removes every daily aggregate
+func (s *Storage) dropAggregates() {
+	_, err := s.redis.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(s.ctx, s.aggregatesKey())
+		pipe.Incr(s.ctx, s.aggregatesVersionKey())
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Failed to drop daily aggregates: %v\n", err)
+	}
+}
+
+// statsFromAggregates computes the stats of [start, end) split into local
+// days. Complete days come from the cache, the others and the cache misses
+// are computed from the events, one Search per run of consecutive days.
+func (s *Storage) statsFromAggregates(start, end int64) (*BabyStats, error) {
+	bounds, err := BucketBounds(start, end, "day", 0, s.loc)
+	if err != nil {
+		return nil, err
+	}
+	firstDay := bounds[0][0]
+	bounds[0][0] = start
+
+	version, err := s.redis.Get(s.ctx, s.aggregatesVersionKey()).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	// The day before the range gives the sessions going on at its start
+	dates := make([]string, len(bounds)+1)
+	dates[0] = time.UnixMilli(firstDay).In(s.loc).AddDate(0, 0, -1).Format("2006-01-02")
+	for i, bucket := range bounds {
+		dates[i+1] = time.UnixMilli(bucket[0]).In(s.loc).Format("2006-01-02")
+	}
+	cached, err := s.redis.HMGet(s.ctx, s.aggregatesKey(), dates...).Result()
+	if err != nil {
+		return nil, err
+	}
+	// Only whole past days are cached
+	now := time.Now().UnixMilli()
+	complete := func(k int) bool {
+		bucket := bounds[k]
+		return (k > 0 || bucket[0] == firstDay) && bucket[1] == nextDay(bucket[0], s.loc) && bucket[1] <= now
+	}
+	aggregates := make([]*dailyAggregate, len(dates))
+	for i, value := range cached {
+		data, ok := value.(string)
+		if !ok || (i > 0 && !complete(i-1)) {
+			continue
+		}
+		var aggregate dailyAggregate
+		if json.Unmarshal([]byte(data), &aggregate) == nil && aggregate.Location == s.loc.String() {
+			aggregates[i] = &aggregate
+		}
+	}
+	previous, aggregates := aggregates[0], aggregates[1:]
+	missing := make([]bool, len(aggregates))
+	for i := range aggregates {
+		missing[i] = aggregates[i] == nil
+	}
+
+	fillAggregates(bounds, aggregates, previous, start, s.Search, s.loc)
+
+	computed := make(map[string]interface{})
+	for k := range aggregates {
+		if !missing[k] || !complete(k) {
+			continue
+		}
+		if data, err := json.Marshal(aggregates[k]); err == nil {
+			computed[dates[k+1]] = string(data)
+		}
+	}
+	if len(computed) > 0 {
+		s.storeAggregates(computed, version)
+	}
+
+	return mergeAggregates(aggregates, start, end), nil
+}
+
+// fillAggregates computes the missing aggregates of the day buckets from the
+// events read with search, one read per run of consecutive missing days.
+// previous is the aggregate of the day before the first bucket, if cached.
+// The first bucket starts at start, possibly after the beginning of its day.
+func fillAggregates(bounds [][2]int64, aggregates []*dailyAggregate, previous *dailyAggregate, start int64, search func(start, end int64) []DBBabyEvent, loc *time.Location) {
+	for i := 0; i < len(bounds); {
+		if aggregates[i] != nil {
+			i++
+			continue
+		}
+		j := i
+		for j < len(bounds) && aggregates[j] == nil {
+			j++
+		}
+
+		var initial sessionState
+		switch {
+		case i > 0:
+			initial = aggregates[i-1].EndState
+		case previous != nil:
+			// Replay the beginning of the first day
+			firstDay := StartOfDay(time.UnixMilli(start), loc).UnixMilli()
+			_, initial = pairSessions(previous.EndState, search(firstDay, start-1), start)
+		default:
+			_, initial = pairSessions(sessionState{}, search(start-statsLookback, start-1), start)
+		}
+		computeAggregates(bounds[i:j], aggregates[i:j], initial, search, loc)
+		i = j
+	}
+}
+
+// computeAggregates fills the aggregates of consecutive buckets from the
+// events, given the sessions going on at the start of the first one
+func computeAggregates(bounds [][2]int64, aggregates []*dailyAggregate, initial sessionState, search func(start, end int64) []DBBabyEvent, loc *time.Location) {
+	start, end := bounds[0][0], bounds[len(bounds)-1][1]
+	events := search(start, end-1)
+	sessions, _ := pairSessions(initial, events, end)
+	i := 0
+	for k, bucket := range bounds {
+		first := i
+		for i < len(events) && events[i].Timestamp < bucket[1] {
+			i++
+		}
+		aggregates[k] = aggregateFromSessions(sessions, events[first:i], bucket[0], bucket[1])
+		aggregates[k].Location = loc.String()
+	}
+}
+
+// storeAggregates caches the computed aggregates, unless an event was
+// written since version was read
+func (s *Storage) storeAggregates(values map[string]interface{}, version int64) {
+	err := s.redis.Watch(s.ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(s.ctx, s.aggregatesVersionKey()).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if current != version {
+			return nil
+		}
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(s.ctx, s.aggregatesKey(), values)
+			return nil
+		})
+		return err
+	}, s.aggregatesVersionKey())
+	if err != nil && err != redis.TxFailedErr {
+		fmt.Printf("Failed to store daily aggregates: %v\n", err)
+	}
+}
+
+// nextDay returns local midnight of the day after the one containing ts
+func nextDay(ts int64, loc *time.Location) int64 {
+	return StartOfDay(time.UnixMilli(ts), loc).AddDate(0, 0, 1).UnixMilli()
+}
+
+// aggregateFromSessions computes the aggregate of [start, end), knowing only
+// the sessions until end
+func aggregateFromSessions(sessions []Session, events []DBBabyEvent, start, end int64) *dailyAggregate {
+	known := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.Start >= end {
+			break
+		}
+		if session.openUntil() > end {
+			session.Open = true
+			session.End = 0
+			session.Duration = end - session.Start
+		}
+		known = append(known, session)
+	}
+
+	aggregate := &dailyAggregate{
+		Stats:      *statsFromSessions(known, events, start, end),
+		FeedStarts: make([]int64, 0),
+	}
+	for _, session := range known {
+		if session.Type == "sleep" {
+			switch {
+			case session.Start < start && session.openUntil() > start:
+				carried := session
+				aggregate.CarriedSleep = &carried
+			case session.Start >= start && !session.Open:
+				aggregate.CompletedSleepTime += session.Duration
+			}
+		}
+		// Sessions left open before the end of the day don't go on
+		if !session.Open || session.openUntil() < end {
+			continue
+		}
+		switch session.Type {
+		case "sleep":
+			aggregate.EndState.SleepStart = session.Start
+		case "leftBoob":
+			aggregate.EndState.LeftStart = session.Start
+		case "rightBoob":
+			aggregate.EndState.RightStart = session.Start
+		}
+	}
+	for _, feedStart := range groupFeeds(known, end) {
+		if feedStart >= start {
+			aggregate.FeedStarts = append(aggregate.FeedStarts, feedStart)
+		}
+	}
+	return aggregate
+}
+
+// mergeAggregates composes the stats of consecutive aggregates covering
+// [start, end)
+func mergeAggregates(aggregates []*dailyAggregate, start, end int64) *BabyStats {
+	stats := &BabyStats{
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+
+	var completedSleepTime, pendingSleep int64
+	feedStarts := make([]int64, 0)
+	for _, aggregate := range aggregates {
+		day := aggregate.Stats
+		stats.SleepTime += day.SleepTime
+		stats.SleepCount += day.SleepCount
+		stats.LeftBoobCount += day.LeftBoobCount
+		stats.RightBoobCount += day.RightBoobCount
+		stats.LeftBoobDuration += day.LeftBoobDuration
+		stats.RightBoobDuration += day.RightBoobDuration
+		stats.PeeCount += day.PeeCount
+		stats.PoopCount += day.PoopCount
+		completedSleepTime += aggregate.CompletedSleepTime
+
+		// A sleep started within the range and ended on this day
+		if carried := aggregate.CarriedSleep; carried != nil && !carried.Open && carried.Start == pendingSleep {
+			stats.SleepCount++
+			completedSleepTime += carried.Duration
+		}
+		pendingSleep = 0
+		if aggregate.EndState.SleepStart >= start {
+			pendingSleep = aggregate.EndState.SleepStart
+		}
+
+		feedStarts = append(feedStarts, aggregate.FeedStarts...)
+		if day.NextSide != "" {
+			stats.NextSide = day.NextSide
+		}
+	}
+
+	if stats.SleepCount > 0 {
+		stats.AverageSleepTime = completedSleepTime / int64(stats.SleepCount)
+	}
+	feedIntervalStats(stats, feedStarts)
+	sideBalance(stats)
+	return stats
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMergeAggregates(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	at := func(day, hour, minute int) int64 {
+		return time.Date(2024, 3, day, hour, minute, 0, 0, paris).UnixMilli()
+	}
+
+	events := []DBBabyEvent{
+		{Timestamp: at(29, 21, 0), Name: "sleep"}, // Night across the DST change
+		{Timestamp: at(30, 3, 0), Name: "leftBoob"},
+		{Timestamp: at(30, 3, 20), Name: "leftBoobStop"},
+		{Timestamp: at(30, 3, 25), Name: "rightBoob"},
+		{Timestamp: at(30, 3, 40), Name: "rightBoobStop"},
+		{Timestamp: at(30, 4, 0), Name: "sleep"},
+		{Timestamp: at(30, 9, 0), Name: "pee"},
+		{Timestamp: at(30, 23, 30), Name: "sleep"}, // Across two midnights
+		{Timestamp: at(31, 12, 0), Name: "sleep"},  // Ignored, already sleeping
+		{Timestamp: at(32, 1, 0), Name: "wake"},
+		{Timestamp: at(32, 1, 30), Name: "leftBoob"},
+		{Timestamp: at(32, 1, 45), Name: "leftBoobStop"},
+		{Timestamp: at(32, 2, 0), Name: "poop"},
+	}
+	start, end := at(29, 12, 0), at(32, 12, 0)
+	want := computeStats(events, start, end, sessionState{})
+
+	// Split the range at local midnights like statsFromAggregates
+	bounds, err := BucketBounds(start, end, "day", 0, paris)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bounds[0][0] = start
+	aggregates := make([]*dailyAggregate, len(bounds))
+	sessions, _ := pairSessions(sessionState{}, events, end)
+	for k, bucket := range bounds {
+		dayEvents := make([]DBBabyEvent, 0)
+		for _, event := range events {
+			if event.Timestamp >= bucket[0] && event.Timestamp < bucket[1] {
+				dayEvents = append(dayEvents, event)
+			}
+		}
+		aggregates[k] = aggregateFromSessions(sessions, dayEvents, bucket[0], bucket[1])
+	}
+
+	got := mergeAggregates(aggregates, start, end)
+
+	if *got != *want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got.SleepCount != 3 {
+		t.Errorf("Expected the sleeps across midnight to be counted, got %d", got.SleepCount)
+	}
+}
+
+// aggregateStats runs the stats of [start, end) through the daily aggregates
+// like statsFromAggregates, with cache standing for the Redis hash
+func aggregateStats(t *testing.T, cache map[string]*dailyAggregate, events []DBBabyEvent, start, end int64, loc *time.Location) *BabyStats {
+	search := func(from, to int64) []DBBabyEvent {
+		found := make([]DBBabyEvent, 0)
+		for _, event := range events {
+			if event.Timestamp >= from && event.Timestamp <= to {
+				found = append(found, event)
+			}
+		}
+		return found
+	}
+	bounds, err := BucketBounds(start, end, "day", 0, loc)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	firstDay := bounds[0][0]
+	bounds[0][0] = start
+	date := func(ts int64) string { return time.UnixMilli(ts).In(loc).Format("2006-01-02") }
+
+	previous := cache[time.UnixMilli(firstDay).In(loc).AddDate(0, 0, -1).Format("2006-01-02")]
+	aggregates := make([]*dailyAggregate, len(bounds))
+	for k, bucket := range bounds {
+		if k > 0 || bucket[0] == firstDay {
+			aggregates[k] = cache[date(bucket[0])]
+		}
+	}
+	fillAggregates(bounds, aggregates, previous, start, search, loc)
+	for k, bucket := range bounds {
+		if k > 0 || bucket[0] == firstDay {
+			cache[date(bucket[0])] = aggregates[k]
+		}
+	}
+	return mergeAggregates(aggregates, start, end)
+}
+
+func TestAggregatesMatchRawStats(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	at := func(day, hour, minute int) int64 {
+		return time.Date(2024, 4, day, hour, minute, 0, 0, paris).UnixMilli()
+	}
+	raw := func(events []DBBabyEvent, start, end int64) *BabyStats {
+		var inRange, before []DBBabyEvent
+		for _, event := range events {
+			switch {
+			case event.Timestamp >= start && event.Timestamp <= end:
+				inRange = append(inRange, event)
+			case event.Timestamp >= start-statsLookback && event.Timestamp < start:
+				before = append(before, event)
+			}
+		}
+		_, initial := pairSessions(sessionState{}, before, start)
+		return computeStats(inRange, start, end, initial)
+	}
+
+	// A left feed never stopped, left open past statsLookback
+	events := []DBBabyEvent{{Timestamp: at(1, 8, 0), Name: "leftBoob"}}
+	for day := 2; day <= 14; day++ {
+		events = append(events,
+			DBBabyEvent{Timestamp: at(day, 7, 0), Name: "rightBoob"},
+			DBBabyEvent{Timestamp: at(day, 7, 20), Name: "rightBoobStop"},
+			DBBabyEvent{Timestamp: at(day, 10, 0), Name: "pee"},
+			DBBabyEvent{Timestamp: at(day, 13, 0), Name: "sleep"},
+			DBBabyEvent{Timestamp: at(day, 15, 0), Name: "wake"},
+			DBBabyEvent{Timestamp: at(day, 21, 0), Name: "sleep"},
+			DBBabyEvent{Timestamp: at(day+1, 6, 0), Name: "wake"}, // Across midnight
+		)
+	}
+	sortEvents := func(events []DBBabyEvent) {
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp < events[j].Timestamp })
+	}
+	sortEvents(events)
+
+	cache := make(map[string]*dailyAggregate)
+	for _, r := range [][2]int64{
+		{at(2, 0, 0), at(15, 0, 0)},  // Fills the cache
+		{at(3, 12, 0), at(14, 0, 0)}, // From the cache, starting mid-day
+		{at(9, 0, 0), at(15, 0, 0)},  // After the open feed stopped counting
+	} {
+		want := raw(events, r[0], r[1])
+		if got := aggregateStats(t, cache, events, r[0], r[1], paris); *got != *want {
+			t.Errorf("Range %d-%d: expected %+v, got %+v", r[0], r[1], want, got)
+		}
+	}
+
+	// Edits only drop the aggregates within the invalidation margin
+	edits := []struct {
+		name  string
+		apply func([]DBBabyEvent) ([]DBBabyEvent, int64)
+	}{
+		{"stop the open feed", func(events []DBBabyEvent) ([]DBBabyEvent, int64) {
+			ts := at(4, 9, 0)
+			return append(events, DBBabyEvent{Timestamp: ts, Name: "leftBoobStop"}), ts
+		}},
+		{"delete a wake", func(events []DBBabyEvent) ([]DBBabyEvent, int64) {
+			ts := at(7, 6, 0)
+			kept := make([]DBBabyEvent, 0, len(events))
+			for _, event := range events {
+				if event.Timestamp != ts {
+					kept = append(kept, event)
+				}
+			}
+			return kept, ts
+		}},
+		{"rename a sleep", func(events []DBBabyEvent) ([]DBBabyEvent, int64) {
+			ts := at(10, 21, 0)
+			for i := range events {
+				if events[i].Timestamp == ts {
+					events[i].Name = "leftBoob"
+				}
+			}
+			return events, ts
+		}},
+	}
+	for _, edit := range edits {
+		var ts int64
+		events, ts = edit.apply(events)
+		sortEvents(events)
+		for _, day := range invalidatedDays(ts, paris) {
+			delete(cache, day)
+		}
+		start, end := at(3, 12, 0), at(15, 0, 0)
+		want := raw(events, start, end)
+		if got := aggregateStats(t, cache, events, start, end, paris); *got != *want {
+			t.Errorf("After %s: expected %+v, got %+v", edit.name, want, got)
+		}
+	}
+}
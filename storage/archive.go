@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// archiveTTL is how long a personal data archive can be downloaded
+	archiveTTL = 24 * time.Hour
+	// archiveJobTimeout is after how long a pending export job is considered
+	// lost, e.g. to a restart, and a new one can be started
+	archiveJobTimeout = 15 * time.Minute
+)
+
+const archiveReadme = `Archive de vos données BabyCheck
+================================
+
+Générée le %s pour le compte « %s ».
+
+Contenu :
+- user.json : votre compte (identifiant, nom du bébé, email, rôle, date de
+  création). Le mot de passe n'est jamais exporté.
+- events.json : tous les événements enregistrés, tels que stockés
+  (horodatages en millisecondes depuis le 01/01/1970 UTC).
+- events.csv : les mêmes événements, les débuts et fins de sommeil et
+  d'allaitement regroupés en sessions avec leur durée.
+- audit.json : le journal des modifications faites par BabyCheck plutôt
+  que par vous (réparations de l'historique, sessions fermées
+  automatiquement), avec l'événement avant et après chaque modification.
+
+Historique des modifications : chaque événement porte un numéro de révision
+(champ « revision ») incrémenté à chaque modification. Seule la dernière
+version de chaque événement est conservée, les versions précédentes des
+événements que vous avez modifiés ou supprimés ne sont pas gardées et ne
+peuvent donc pas être exportées. Les modifications automatiques sont dans
+audit.json.
+
+Ce lien de téléchargement expire 24 heures après l'envoi de l'email.
+`
+
+// ArchiveStatus describes the state of a personal data export job
+type ArchiveStatus struct {
+	Status    string `json:"status"`    // "pending", "ready" or "error"
+	Requested int64  `json:"requested"` // Start of the job
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Running tells whether the job is still building the archive. A job
+// pending for longer than archiveJobTimeout is stale and doesn't block a
+// new request.
+func (status *ArchiveStatus) Running(now time.Time) bool {
+	return status.Status == "pending" && now.Sub(time.UnixMilli(status.Requested)) < archiveJobTimeout
+}
+
+// BuildDataArchive bundles everything stored about a user into a zip with
+// JSON and CSV files and a README
+func BuildDataArchive(user *User, events []DBBabyEvent, audit []AuditEntry, loc *time.Location) ([]byte, error) {
+	// Never export the password hash
+	account := *user
+	account.Password = ""
+
+	var buffer bytes.Buffer
+	zw := zip.NewWriter(&buffer)
+
+	readme, err := zw.Create("README.txt")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(readme, archiveReadme, time.Now().In(loc).Format("02/01/2006 15:04"), account.Username)
+
+	for name, value := range map[string]interface{}{
+		"user.json":   account,
+		"events.json": events,
+		"audit.json":  audit,
+	} {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(fw)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			return nil, err
+		}
+	}
+
+	csvFile, err := zw.Create("events.csv")
+	if err != nil {
+		return nil, err
+	}
+	rows := BuildExportRows(events, time.Now().UnixMilli())
+	if err := WriteExport(csvFile, "csv", rows, loc); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// SetArchiveStatus records the state of the user's data export job
+func (us *UserStorage) SetArchiveStatus(userID string, status *ArchiveStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return us.redis.Set(us.ctx, fmt.Sprintf("user:%s:archive_status", userID), string(data), archiveTTL).Err()
+}
+
+// GetArchiveStatus returns the state of the user's last data export job
+func (us *UserStorage) GetArchiveStatus(userID string) (*ArchiveStatus, error) {
+	data, err := us.redis.Get(us.ctx, fmt.Sprintf("user:%s:archive_status", userID)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("no archive requested")
+		}
+		return nil, err
+	}
+	var status ArchiveStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StoreDataArchive keeps the archive for archiveTTL and returns the secret
+// token of its download link
+func (us *UserStorage) StoreDataArchive(archive []byte) (string, error) {
+	token := us.GeneratePasswordResetToken()
+	err := us.redis.Set(us.ctx, fmt.Sprintf("data_archive:%s", token), archive, archiveTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store data archive: %w", err)
+	}
+	return token, nil
+}
+
+// GetDataArchive returns an archive by its download token
+func (us *UserStorage) GetDataArchive(token string) ([]byte, error) {
+	archive, err := us.redis.Get(us.ctx, fmt.Sprintf("data_archive:%s", token)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("archive expired or not found")
+		}
+		return nil, err
+	}
+	return archive, nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDataArchive(t *testing.T) {
+	user := &User{ID: "u1", Username: "Léa", Password: "$2a$10$secrethash", Email: "parent@example.com"}
+	events := []DBBabyEvent{
+		{ID: "1", Timestamp: 1000000000000, Name: "sleep"},
+		{ID: "2", Timestamp: 1000001800000, Name: "wake"},
+	}
+
+	audit := []AuditEntry{{At: 1000003600000, Actor: "system", Action: "auto-close", After: &events[1]}}
+
+	archive, err := BuildDataArchive(user, events, audit, time.UTC)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+
+	for _, name := range []string{"README.txt", "user.json", "events.json", "events.csv", "audit.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in archive", name)
+		}
+	}
+	if !strings.Contains(files["audit.json"], `"auto-close"`) {
+		t.Errorf("Expected the audit entries in audit.json, got %s", files["audit.json"])
+	}
+	for name, content := range files {
+		if strings.Contains(content, "secrethash") {
+			t.Errorf("Password hash leaked in %s", name)
+		}
+	}
+	if user.Password == "" {
+		t.Error("BuildDataArchive must not modify the user")
+	}
+}
+
+func TestArchiveStatusRunning(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		status  ArchiveStatus
+		running bool
+	}{
+		{"Just started", ArchiveStatus{Status: "pending", Requested: now.Add(-time.Minute).UnixMilli()}, true},
+		{"Stale", ArchiveStatus{Status: "pending", Requested: now.Add(-archiveJobTimeout).UnixMilli()}, false},
+		{"Failed", ArchiveStatus{Status: "error", Requested: now.UnixMilli()}, false},
+		{"Ready", ArchiveStatus{Status: "ready", Requested: now.UnixMilli()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if running := tt.status.Running(now); running != tt.running {
+				t.Errorf("Expected running %v, got %v", tt.running, running)
+			}
+		})
+	}
+}
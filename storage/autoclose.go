@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultSessionDurations are the durations given to an auto-closed session
+// when the baby has no recent completed session of the same type
+var defaultSessionDurations = map[string]int64{
+	"sleep":     2 * 60 * 60 * 1000,
+	"leftBoob":  15 * 60 * 1000,
+	"rightBoob": 15 * 60 * 1000,
+}
+
+// LoadMaxSessionDurations overrides MaxSessionDurations from the environment:
+// MAX_SLEEP_DURATION for sleeps and MAX_FEED_DURATION for both sides, as Go
+// durations like "14h" or "90m"
+func LoadMaxSessionDurations() error {
+	for variable, kinds := range map[string][]string{
+		"MAX_SLEEP_DURATION": {"sleep"},
+		"MAX_FEED_DURATION":  {"leftBoob", "rightBoob"},
+	} {
+		value := os.Getenv(variable)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid %s %q", variable, value)
+		}
+		for _, kind := range kinds {
+			MaxSessionDurations[kind] = duration.Milliseconds()
+		}
+	}
+	return nil
+}
+
+// plausibleEnd returns when an open session started at start most likely
+// ended: after the median duration of the completed sessions of the same
+// type, bounded by MaxSessionDurations
+func plausibleEnd(kind string, start int64, history []Session) int64 {
+	durations := make([]int64, 0)
+	for _, session := range history {
+		if session.Type == kind && !session.Open {
+			durations = append(durations, session.Duration)
+		}
+	}
+	duration := defaultSessionDurations[kind]
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		duration = durations[len(durations)/2]
+	}
+	if duration > MaxSessionDurations[kind] {
+		duration = MaxSessionDurations[kind]
+	}
+	return start + duration
+}
+
+// AutoCloseSessions stops the sessions still open at now for longer than
+// MaxSessionDurations, at their plausible end. The stop events are marked
+// auto-closed, carry the author of the start and are recorded in the audit
+// log. It returns the inserted events.
+func (s *Storage) AutoCloseSessions(now int64) ([]DBBabyEvent, error) {
+	closed := make([]DBBabyEvent, 0)
+	for _, kind := range []string{"sleep", "leftBoob", "rightBoob"} {
+		stop, err := s.autoCloseSession(kind, now)
+		if err != nil {
+			return closed, err
+		}
+		if stop == nil {
+			continue
+		}
+		s.audit(AuditEntry{
+			At:     now,
+			Actor:  "system",
+			Action: "auto-close",
+			After:  stop,
+		})
+		closed = append(closed, *stop)
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].Timestamp < closed[j].Timestamp })
+	return closed, nil
+}
+
+// autoCloseSession inserts the stop of the session of the given type when it
+// is still open at now for too long. The session is checked again inside the
+// transaction writing the stop, so another server or a parent stopping it
+// meanwhile doesn't lead to a second stop. It returns nil when nothing was
+// inserted.
+func (s *Storage) autoCloseSession(kind string, now int64) (*DBBabyEvent, error) {
+	var stop *DBBabyEvent
+	txf := func(tx *redis.Tx) error {
+		stop = nil
+		state := s.sessionStateAt(now)
+		start := openSince(state, kind)
+		if start == 0 || now-start <= MaxSessionDurations[kind] {
+			return nil
+		}
+
+		stop = &DBBabyEvent{
+			ID:        uuid.New().String(),
+			Timestamp: plausibleEnd(kind, start, s.GetSessions(start-statsLookback, start-1)),
+			Name:      sessionStops[kind],
+			Revision:  1,
+			Source:    SourceAutoClosed,
+		}
+		if startEvent, err := s.GetEvent(start); err == nil {
+			stop.Author = startEvent.Author
+		}
+		member, err := stop.Json()
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(s.ctx, s.eventsKey(), redis.Z{Score: float64(stop.Timestamp), Member: member})
+			return nil
+		})
+		return err
+	}
+
+	// Retry when another write happened between the check and the insert
+	for i := 0; i < 3; i++ {
+		err := s.redis.Watch(s.ctx, txf, s.eventsKey())
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stop != nil {
+			s.invalidateAggregates(stop.Timestamp)
+			s.publish("created", stop, stop.Timestamp)
+		}
+		return stop, nil
+	}
+	return nil, redis.TxFailedErr
+}
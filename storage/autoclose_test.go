@@ -0,0 +1,58 @@
+package storage
+
+import "testing"
+
+func TestPlausibleEnd(t *testing.T) {
+	minute := int64(60 * 1000)
+	start := int64(1000000000000)
+
+	t.Run("Median of the recent sessions", func(t *testing.T) {
+		history := []Session{
+			{Type: "leftBoob", Duration: 10 * minute},
+			{Type: "leftBoob", Duration: 30 * minute},
+			{Type: "leftBoob", Duration: 20 * minute},
+			{Type: "rightBoob", Duration: 60 * minute},
+			{Type: "leftBoob", Duration: 500 * minute, Open: true},
+		}
+		if end := plausibleEnd("leftBoob", start, history); end != start+20*minute {
+			t.Errorf("Expected the median of 20 minutes, got %d", end-start)
+		}
+	})
+
+	t.Run("Default without history", func(t *testing.T) {
+		if end := plausibleEnd("sleep", start, nil); end != start+defaultSessionDurations["sleep"] {
+			t.Errorf("Expected the default sleep duration, got %d", end-start)
+		}
+	})
+
+	t.Run("Bounded by the maximum", func(t *testing.T) {
+		history := []Session{{Type: "rightBoob", Duration: 300 * minute}}
+		if end := plausibleEnd("rightBoob", start, history); end != start+MaxSessionDurations["rightBoob"] {
+			t.Errorf("Expected the maximum feed duration, got %d", end-start)
+		}
+	})
+}
+
+func TestLoadMaxSessionDurations(t *testing.T) {
+	defaults := make(map[string]int64)
+	for kind, max := range MaxSessionDurations {
+		defaults[kind] = max
+	}
+	defer func() { MaxSessionDurations = defaults }()
+
+	t.Setenv("MAX_FEED_DURATION", "90m")
+	if err := LoadMaxSessionDurations(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if MaxSessionDurations["leftBoob"] != 90*60*1000 || MaxSessionDurations["rightBoob"] != 90*60*1000 {
+		t.Errorf("Expected 90 minutes for both sides, got %v", MaxSessionDurations)
+	}
+	if MaxSessionDurations["sleep"] != defaults["sleep"] {
+		t.Errorf("Expected the default sleep maximum, got %d", MaxSessionDurations["sleep"])
+	}
+
+	t.Setenv("MAX_SLEEP_DURATION", "forever")
+	if err := LoadMaxSessionDurations(); err == nil {
+		t.Errorf("Expected an error for an invalid duration")
+	}
+}
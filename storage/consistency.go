@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MaxSessionDurations is how long a session may last, in milliseconds, before
+// the checker reports it as left open
+var MaxSessionDurations = map[string]int64{
+	"sleep":     14 * 60 * 60 * 1000,
+	"leftBoob":  2 * 60 * 60 * 1000,
+	"rightBoob": 2 * 60 * 60 * 1000,
+}
+
+// maxAuditEntries bounds the audit log of a user
+const maxAuditEntries = 1000
+
+var sessionStops = map[string]string{
+	"sleep":     "wake",
+	"leftBoob":  "leftBoobStop",
+	"rightBoob": "rightBoobStop",
+}
+
+var sessionLabels = map[string]string{
+	"sleep":     "sommeil",
+	"leftBoob":  "tétée gauche",
+	"rightBoob": "tétée droite",
+}
+
+// Issue is an invalid sequence of events, with the fixes that would repair
+// it. The first fix is the suggested one.
+type Issue struct {
+	ID      string      `json:"id"`
+	Kind    string      `json:"kind"` // "orphan_stop", "double_start" or "long_session"
+	Event   DBBabyEvent `json:"event"`
+	Message string      `json:"message"`
+	Fixes   []Fix       `json:"fixes"`
+}
+
+// Fix is a repair of an issue: deleting, renaming or moving an event, or
+// inserting the missing stop
+type Fix struct {
+	ID           string `json:"id"` // "delete", "rename", "move" or "close"
+	Description  string `json:"description"`
+	Timestamp    int64  `json:"timestamp"`               // Event to change, or new event for "close"
+	Revision     int64  `json:"revision,omitempty"`      // Expected revision of the event to change
+	Name         string `json:"name,omitempty"`          // New name for "rename", name of the new event for "close"
+	NewTimestamp int64  `json:"new_timestamp,omitempty"` // For "move"
+
+	target DBBabyEvent
+}
+
+// RepairChoice selects the fix to apply to an issue
+type RepairChoice struct {
+	IssueID string `json:"issue_id"`
+	Fix     string `json:"fix"`
+}
+
+// RepairResult is the outcome of a repair
+type RepairResult struct {
+	IssueID string       `json:"issue_id"`
+	Fix     string       `json:"fix"`
+	Status  string       `json:"status"` // "applied", "resolved" when the issue is gone, or "error"
+	Event   *DBBabyEvent `json:"event,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// AuditEntry records a change made to the events on behalf of someone other
+// than the parents tapping, with the event before and after it
+type AuditEntry struct {
+	At      int64        `json:"at"`
+	Actor   string       `json:"actor"`
+	Action  string       `json:"action"` // "repair" or "auto-close"
+	IssueID string       `json:"issue_id,omitempty"`
+	Fix     string       `json:"fix,omitempty"`
+	Before  *DBBabyEvent `json:"before,omitempty"` // nil for an inserted event
+	After   *DBBabyEvent `json:"after,omitempty"`  // nil for a deleted event
+}
+
+// CheckEvents scans the events in timestamp order with the pairing rules of
+// CalculateStats and reports the stops without a start, the starts of a
+// session already going on and the sessions longer than MaxSessionDurations,
+// still open at now or not
+func CheckEvents(events []DBBabyEvent, now int64) []Issue {
+	issues := make([]Issue, 0)
+	open := make(map[string]*DBBabyEvent)
+
+	closeSession := func(kind string, end DBBabyEvent) {
+		start := open[kind]
+		if start == nil {
+			return
+		}
+		delete(open, kind)
+		if end.Timestamp-start.Timestamp <= MaxSessionDurations[kind] {
+			return
+		}
+		var fix Fix
+		if end.Name == sessionStops[kind] {
+			fix = moveFix(end, start.Timestamp+MaxSessionDurations[kind])
+		} else {
+			fix = closeFix(kind, start.Timestamp+MaxSessionDurations[kind])
+		}
+		issues = append(issues, longSessionIssue(kind, *start, end.Timestamp, fix))
+	}
+
+	for i := range events {
+		event := events[i]
+		switch event.Name {
+		case "sleep", "leftBoob", "rightBoob":
+			// A feed start ends the sleep even when the feed is already going on
+			if event.Name != "sleep" {
+				closeSession("sleep", event)
+			}
+			if open[event.Name] != nil {
+				label := sessionLabels[event.Name]
+				issues = append(issues, Issue{
+					ID:      issueID("double_start", event),
+					Kind:    "double_start",
+					Event:   event,
+					Message: fmt.Sprintf("Début de %s alors qu'il est déjà en cours", label),
+					Fixes: []Fix{
+						deleteFix(event),
+						{
+							ID:          "rename",
+							Description: fmt.Sprintf("Transformer en fin de %s", label),
+							Timestamp:   event.Timestamp,
+							Revision:    event.Revision,
+							Name:        sessionStops[event.Name],
+							target:      event,
+						},
+					},
+				})
+				continue
+			}
+			open[event.Name] = &event
+		case "wake", "leftBoobStop", "rightBoobStop":
+			kind := sessionOf(event.Name)
+			if open[kind] == nil {
+				issues = append(issues, Issue{
+					ID:      issueID("orphan_stop", event),
+					Kind:    "orphan_stop",
+					Event:   event,
+					Message: fmt.Sprintf("Fin de %s sans début", sessionLabels[kind]),
+					Fixes:   []Fix{deleteFix(event)},
+				})
+				continue
+			}
+			closeSession(kind, event)
+		case "pee", "poop":
+			closeSession("sleep", event)
+		}
+	}
+
+	for _, kind := range []string{"sleep", "leftBoob", "rightBoob"} {
+		start := open[kind]
+		if start != nil && now-start.Timestamp > MaxSessionDurations[kind] {
+			issues = append(issues, longSessionIssue(kind, *start, now, closeFix(kind, start.Timestamp+MaxSessionDurations[kind])))
+		}
+	}
+	return issues
+}
+
+// sessionOf returns the session type closed by a stop event
+func sessionOf(stop string) string {
+	for kind, name := range sessionStops {
+		if name == stop {
+			return kind
+		}
+	}
+	return ""
+}
+
+func issueID(kind string, event DBBabyEvent) string {
+	return fmt.Sprintf("%s:%d:%s", kind, event.Timestamp, event.ID)
+}
+
+func longSessionIssue(kind string, start DBBabyEvent, end int64, fix Fix) Issue {
+	duration := time.Duration(end-start.Timestamp) * time.Millisecond
+	max := time.Duration(MaxSessionDurations[kind]) * time.Millisecond
+	return Issue{
+		ID:      issueID("long_session", start),
+		Kind:    "long_session",
+		Event:   start,
+		Message: fmt.Sprintf("%s de %s (maximum %s)", sessionLabels[kind], duration.Round(time.Minute), max),
+		Fixes:   []Fix{fix, deleteFix(start)},
+	}
+}
+
+func deleteFix(event DBBabyEvent) Fix {
+	return Fix{
+		ID:          "delete",
+		Description: "Supprimer l'événement",
+		Timestamp:   event.Timestamp,
+		Revision:    event.Revision,
+		target:      event,
+	}
+}
+
+func moveFix(stop DBBabyEvent, to int64) Fix {
+	return Fix{
+		ID:           "move",
+		Description:  "Avancer la fin à la durée maximale",
+		Timestamp:    stop.Timestamp,
+		Revision:     stop.Revision,
+		NewTimestamp: to,
+		target:       stop,
+	}
+}
+
+func closeFix(kind string, at int64) Fix {
+	return Fix{
+		ID:          "close",
+		Description: "Ajouter la fin à la durée maximale",
+		Timestamp:   at,
+		Name:        sessionStops[kind],
+	}
+}
+
+// CheckConsistency checks the whole history of the user
+func (s *Storage) CheckConsistency(now int64) []Issue {
+	return CheckEvents(s.GetAllData(), now)
+}
+
+// Repair applies the chosen fixes to the issues found by a single check of
+// the history, in the timestamp order of the events they change. A fix is
+// only applied while the event it changes still has the revision the issue
+// was found with, so a fix made stale by an earlier one fails instead of
+// being applied twice. Every applied fix is recorded in the audit log. The
+// issues left after the fixes are returned with the results.
+func (s *Storage) Repair(choices []RepairChoice, actor string, now int64) ([]RepairResult, []Issue) {
+	results, fixes := chooseFixes(s.CheckConsistency(now), choices)
+	for _, i := range repairOrder(fixes) {
+		before, after, err := s.applyFix(fixes[i])
+		if err != nil {
+			results[i].Status = "error"
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Status = "applied"
+		results[i].Event = after
+		if after == nil {
+			results[i].Event = before
+		}
+		s.audit(AuditEntry{
+			At:      now,
+			Actor:   actor,
+			Action:  "repair",
+			IssueID: choices[i].IssueID,
+			Fix:     choices[i].Fix,
+			Before:  before,
+			After:   after,
+		})
+	}
+	return results, s.CheckConsistency(now)
+}
+
+// chooseFixes finds the fix of every choice among the issues. A choice
+// whose issue is gone is "resolved" and gets no fix, like a choice of an
+// unknown fix, which is an error.
+func chooseFixes(issues []Issue, choices []RepairChoice) ([]RepairResult, []*Fix) {
+	byID := make(map[string]*Issue, len(issues))
+	for i := range issues {
+		byID[issues[i].ID] = &issues[i]
+	}
+	results := make([]RepairResult, len(choices))
+	fixes := make([]*Fix, len(choices))
+	for i, choice := range choices {
+		results[i] = RepairResult{IssueID: choice.IssueID, Fix: choice.Fix, Status: "resolved"}
+		issue := byID[choice.IssueID]
+		if issue == nil {
+			continue
+		}
+		for j := range issue.Fixes {
+			if issue.Fixes[j].ID == choice.Fix {
+				fixes[i] = &issue.Fixes[j]
+			}
+		}
+		if fixes[i] == nil {
+			results[i].Status = "error"
+			results[i].Error = fmt.Sprintf("unknown fix %q", choice.Fix)
+		}
+	}
+	return results, fixes
+}
+
+// repairOrder returns the indexes of the fixes to apply, by timestamp of the
+// event they change
+func repairOrder(fixes []*Fix) []int {
+	order := make([]int, 0, len(fixes))
+	for i, fix := range fixes {
+		if fix != nil {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return fixes[order[a]].Timestamp < fixes[order[b]].Timestamp })
+	return order
+}
+
+// applyFix writes a fix and returns the event before and after it
+func (s *Storage) applyFix(fix *Fix) (*DBBabyEvent, *DBBabyEvent, error) {
+	before := &fix.target
+	switch fix.ID {
+	case "delete":
+		deleted, err := s.Delete(fix.Timestamp, fix.Revision)
+		return deleted, nil, err
+	case "rename":
+		updated, err := s.UpdateEvent(fix.Timestamp, fix.Name, fix.Revision)
+		return before, updated, err
+	case "move":
+		updated, err := s.ChangeTimestamp(fix.target, fix.NewTimestamp, fix.Revision)
+		return before, updated, err
+	case "close":
+		saved, err := s.SaveEvent(DBBabyEvent{Timestamp: fix.Timestamp, Name: fix.Name, Source: SourceInferred})
+		return nil, saved, err
+	}
+	return nil, nil, fmt.Errorf("unknown fix %q", fix.ID)
+}
+
+// auditKey returns the list holding the audit log, newest first
+func (s *Storage) auditKey() string {
+	return s.eventsKey() + ":audit"
+}
+
+// audit records an entry in the audit log. Failures are only logged, the
+// change itself succeeded.
+func (s *Storage) audit(entry AuditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	pipe := s.redis.TxPipeline()
+	pipe.LPush(s.ctx, s.auditKey(), string(data))
+	pipe.LTrim(s.ctx, s.auditKey(), 0, maxAuditEntries-1)
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		fmt.Printf("Failed to write audit entry: %v\n", err)
+	}
+}
+
+// GetAuditLog returns the latest audit entries, newest first
+func (s *Storage) GetAuditLog(limit int) ([]AuditEntry, error) {
+	if limit <= 0 || limit > maxAuditEntries {
+		limit = maxAuditEntries
+	}
+	values, err := s.redis.LRange(s.ctx, s.auditKey(), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(values))
+	for _, value := range values {
+		var entry AuditEntry
+		if json.Unmarshal([]byte(value), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
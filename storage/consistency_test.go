@@ -0,0 +1,85 @@
+package storage
+
+import "testing"
+
+func TestCheckEvents(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+	hour := 60 * minute
+
+	events := []DBBabyEvent{
+		{ID: "a", Timestamp: baseTime, Name: "leftBoobStop", Revision: 1},             // Orphan stop
+		{ID: "b", Timestamp: baseTime + minute, Name: "sleep", Revision: 1},           // Sleep start
+		{ID: "c", Timestamp: baseTime + 2*minute, Name: "sleep", Revision: 1},         // Double start
+		{ID: "d", Timestamp: baseTime + hour, Name: "rightBoob", Revision: 1},         // Ends the sleep
+		{ID: "e", Timestamp: baseTime + 4*hour, Name: "rightBoobStop", Revision: 2},   // Three hours of feeding
+		{ID: "f", Timestamp: baseTime + 5*hour, Name: "leftBoob", Revision: 1},        // Never stopped
+		{ID: "g", Timestamp: baseTime + 5*hour + minute, Name: "pee", Revision: 1},    // Valid
+		{ID: "h", Timestamp: baseTime + 5*hour + 2*minute, Name: "wake", Revision: 1}, // Orphan stop
+	}
+	issues := CheckEvents(events, baseTime+10*hour)
+
+	expected := []struct {
+		kind, eventID, fix string
+	}{
+		{"orphan_stop", "a", "delete"},
+		{"double_start", "c", "delete"},
+		{"long_session", "d", "move"},
+		{"orphan_stop", "h", "delete"},
+		{"long_session", "f", "close"},
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("Expected %d issues, got %+v", len(expected), issues)
+	}
+	for i, e := range expected {
+		issue := issues[i]
+		if issue.Kind != e.kind || issue.Event.ID != e.eventID || issue.Fixes[0].ID != e.fix {
+			t.Errorf("Issue %d: expected %s on %s fixed by %s, got %s on %s fixed by %s",
+				i, e.kind, e.eventID, e.fix, issue.Kind, issue.Event.ID, issue.Fixes[0].ID)
+		}
+	}
+
+	if move := issues[2].Fixes[0]; move.Timestamp != baseTime+4*hour || move.Revision != 2 || move.NewTimestamp != baseTime+3*hour {
+		t.Errorf("Expected the feed stop moved to two hours after the start, got %+v", move)
+	}
+	if close := issues[4].Fixes[0]; close.Name != "leftBoobStop" || close.Timestamp != baseTime+7*hour {
+		t.Errorf("Expected a left feed stop two hours after the start, got %+v", close)
+	}
+	if rename := issues[1].Fixes[1]; rename.ID != "rename" || rename.Name != "wake" {
+		t.Errorf("Expected the double sleep renamed to wake, got %+v", rename)
+	}
+}
+
+func TestChooseFixes(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+
+	events := []DBBabyEvent{
+		{ID: "a", Timestamp: baseTime, Name: "wake", Revision: 1},
+		{ID: "b", Timestamp: baseTime + minute, Name: "sleep", Revision: 1},
+		{ID: "c", Timestamp: baseTime + 2*minute, Name: "sleep", Revision: 1},
+	}
+	issues := CheckEvents(events, baseTime+3*minute)
+	choices := []RepairChoice{
+		{IssueID: issues[1].ID, Fix: "rename"},
+		{IssueID: "orphan_stop:0:gone", Fix: "delete"},
+		{IssueID: issues[0].ID, Fix: "move"},
+		{IssueID: issues[0].ID, Fix: "delete"},
+	}
+	results, fixes := chooseFixes(issues, choices)
+
+	statuses := []string{"resolved", "resolved", "error", "resolved"}
+	for i, status := range statuses {
+		if results[i].Status != status {
+			t.Errorf("Choice %d: expected %s, got %+v", i, status, results[i])
+		}
+	}
+	// The fixes apply in the order of the events, not of the choices
+	order := repairOrder(fixes)
+	if len(order) != 2 || order[0] != 3 || order[1] != 0 {
+		t.Fatalf("Expected the orphan wake fixed before the double sleep, got %v", order)
+	}
+	if fixes[0].Name != "wake" || fixes[3].Timestamp != baseTime {
+		t.Errorf("Expected the rename to wake and the delete of the orphan, got %+v %+v", fixes[0], fixes[3])
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/textproto"
 	"os"
 	"strings"
+	"time"
 )
 
 type EmailService struct {
@@ -162,6 +163,98 @@ func (e *EmailService) SendPasswordResetLink(to, token string) error {
 	return e.SendEmail(to, subject, body)
 }
 
+func (e *EmailService) SendDataArchiveLink(to, token string) error {
+	subject := "Vos données BabyCheck sont prêtes"
+
+	// Get the base URL from environment or use default for development
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080" // Default for development
+	}
+
+	downloadLink := fmt.Sprintf("%s/api/archive/%s", baseURL, token)
+
+	body := fmt.Sprintf(`
+		<h2>Téléchargement de vos données</h2>
+		<p>L'archive contenant toutes les données de votre compte BabyCheck est prête.</p>
+		<p><a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">Télécharger mes données</a></p>
+		<p>Ou copiez ce lien dans votre navigateur :</p>
+		<p style="word-break: break-all; font-family: monospace; background-color: #f5f5f5; padding: 8px; border-radius: 4px;">%s</p>
+		<p><strong>Important :</strong></p>
+		<ul>
+			<li>Ce lien expire dans 24 heures</li>
+			<li>Toute personne disposant du lien peut télécharger l'archive, ne le partagez pas</li>
+			<li>Si vous n'avez pas demandé cet export, changez votre mot de passe</li>
+		</ul>
+		<hr>
+		<small>Cet email a été envoyé depuis votre application BabyCheck.</small>
+	`, downloadLink, downloadLink)
+
+	return e.SendEmail(to, subject, body)
+}
+
+func (e *EmailService) SendPredictionReminder(to, babyName, kind string, prediction *Prediction, loc *time.Location) error {
+	what := "la prochaine tétée"
+	if kind == "nap" {
+		what = "la prochaine sieste"
+	}
+	format := func(ts int64) string {
+		return time.UnixMilli(ts).In(loc).Format("15h04")
+	}
+	subject := fmt.Sprintf("%s : %s vers %s", babyName, what, format(prediction.Expected))
+
+	body := fmt.Sprintf(`
+		<h2>Rappel pour %s</h2>
+		<p>D'après les %d derniers intervalles, %s est attendue vers <strong>%s</strong> (entre %s et %s).</p>
+		<hr>
+		<small>Vous pouvez désactiver ces rappels dans votre profil BabyCheck.</small>
+	`, babyName, prediction.Samples, what, format(prediction.Expected), format(prediction.Earliest), format(prediction.Latest))
+
+	return e.SendEmail(to, subject, body)
+}
+
+func (e *EmailService) SendNormAlerts(to, babyName string, alerts []Alert) error {
+	subject := fmt.Sprintf("%s : points à surveiller", babyName)
+
+	items := ""
+	for _, alert := range alerts {
+		items += fmt.Sprintf("<li>%s : %s</li>", alert.Date, alert.Message)
+	}
+	body := fmt.Sprintf(`
+		<h2>Points à surveiller pour %s</h2>
+		<p>Les données d'hier sortent des repères habituels pour l'âge de votre bébé :</p>
+		<ul>%s</ul>
+		<p>Ces repères sont indicatifs. En cas de doute, parlez-en à votre sage-femme ou à votre médecin.</p>
+		<hr>
+		<small>Vous pouvez désactiver ces alertes dans votre profil BabyCheck.</small>
+	`, babyName, items)
+
+	return e.SendEmail(to, subject, body)
+}
+
+func (e *EmailService) SendAutoCloseNotice(to, babyName string, events []DBBabyEvent, loc *time.Location) error {
+	subject := fmt.Sprintf("%s : session fermée automatiquement", babyName)
+
+	items := ""
+	for _, event := range events {
+		items += fmt.Sprintf("<li>%s à %s</li>", autoCloseLabels[event.Name], time.UnixMilli(event.Timestamp).In(loc).Format("02/01 15:04"))
+	}
+	body := fmt.Sprintf(`
+		<h2>Sessions fermées automatiquement pour %s</h2>
+		<p>Ces sessions étaient ouvertes depuis trop longtemps, la fin a été ajoutée à une heure probable :</p>
+		<ul>%s</ul>
+		<p>Corrigez l'heure dans BabyCheck si elle ne correspond pas.</p>
+	`, babyName, items)
+
+	return e.SendEmail(to, subject, body)
+}
+
+var autoCloseLabels = map[string]string{
+	"wake":          "Réveil",
+	"leftBoobStop":  "Fin de tétée gauche",
+	"rightBoobStop": "Fin de tétée droite",
+}
+
 func (e *EmailService) SendEmailWithImage(to, subject, body, base64Image string) error {
 	// Configuration TLS
 	tlsConfig := &tls.Config{
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExportFormats lists the supported export formats with their content type
+var ExportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportColumns are the columns of the CSV and XLSX exports, in order
+var exportColumns = []string{"label", "start", "end", "duration", "open", "note"}
+
+// exportLabels is the localization of the exports: the events, the sessions
+// pairing them and the column headers
+type exportLabels struct {
+	Events   map[string]string
+	Sessions map[string]string
+	Columns  map[string]string
+}
+
+// frenchLabels are the French labels, as shown in emails and exports
+var frenchLabels = exportLabels{
+	Events: map[string]string{
+		"sleep":         "💤 Début du sommeil",
+		"wake":          "☀️ Réveil",
+		"leftBoob":      "🍼 Début allaitement (sein gauche)",
+		"leftBoobStop":  "🍼 Fin allaitement (sein gauche)",
+		"rightBoob":     "🍼 Début allaitement (sein droit)",
+		"rightBoobStop": "🍼 Fin allaitement (sein droit)",
+		"pee":           "💧 Pipi",
+		"poop":          "💩 Caca",
+		"vaccine":       "💉 Vaccin",
+		"appointment":   "🩺 Rendez-vous médical",
+	},
+	Sessions: map[string]string{
+		"sleep":     "💤 Sommeil",
+		"leftBoob":  "🍼 Allaitement (sein gauche)",
+		"rightBoob": "🍼 Allaitement (sein droit)",
+	},
+	Columns: map[string]string{
+		"label":    "Événement",
+		"start":    "Début",
+		"end":      "Fin",
+		"duration": "Durée (min)",
+		"open":     "En cours",
+		"note":     "Note",
+	},
+}
+
+// exportHeaders returns the column headers of the CSV and XLSX exports
+func exportHeaders() []string {
+	headers := make([]string, len(exportColumns))
+	for i, column := range exportColumns {
+		headers[i] = frenchLabels.Columns[column]
+	}
+	return headers
+}
+
+// ExportRow is one line of an export: either a session or a single event
+type ExportRow struct {
+	Name     string `json:"name"`               // Session type or event name
+	Label    string `json:"label"`              // Display name
+	Start    int64  `json:"start"`              // Timestamp in milliseconds
+	End      int64  `json:"end,omitempty"`      // Session end in milliseconds
+	Duration int64  `json:"duration,omitempty"` // Session duration in milliseconds
+	Open     bool   `json:"open,omitempty"`     // Session without stop event
+	Note     string `json:"note,omitempty"`     // Note of the event, or of the session start
+	Session  bool   `json:"session"`
+}
+
+// EventDisplayName returns the French label of an event, as shown in emails
+// and exports
+func EventDisplayName(eventName string) string {
+	if label, ok := frenchLabels.Events[eventName]; ok {
+		return label
+	}
+	return eventName
+}
+
+// SessionDisplayName returns the French label of a session, from its start
+// to its stop
+func SessionDisplayName(sessionType string) string {
+	if label, ok := frenchLabels.Sessions[sessionType]; ok {
+		return label
+	}
+	return sessionType
+}
+
+// BuildExportRows turns events into export rows: start/stop pairs become a
+// single session row, other events are kept as they are
+func BuildExportRows(events []DBBabyEvent, until int64) []ExportRow {
+	return buildExportRows(PairSessions(events, until), events, until)
+}
+
+// ExportRows returns the export rows of [start, end], including the sessions
+// that started before start
+func (s *Storage) ExportRows(start, end int64) []ExportRow {
+	events := s.Search(start, end)
+	sessions, _ := pairSessions(s.sessionStateAt(start), events, end)
+	return buildExportRows(sessions, events, end)
+}
+
+func buildExportRows(sessions []Session, events []DBBabyEvent, until int64) []ExportRow {
+	rows := make([]ExportRow, 0, len(events))
+	notes := make(map[string]string)
+	for _, event := range events {
+		if event.Note != "" {
+			notes[fmt.Sprintf("%d:%s", event.Timestamp, event.Name)] = event.Note
+		}
+	}
+	i := 0
+	appendSessionsUntil := func(ts int64) {
+		for i < len(sessions) && sessions[i].Start <= ts {
+			session := sessions[i]
+			rows = append(rows, ExportRow{
+				Name:     session.Type,
+				Label:    SessionDisplayName(session.Type),
+				Start:    session.Start,
+				End:      session.End,
+				Duration: session.Duration,
+				Open:     session.Open,
+				Note:     notes[fmt.Sprintf("%d:%s", session.Start, session.Type)],
+				Session:  true,
+			})
+			i++
+		}
+	}
+
+	for _, event := range events {
+		if isSessionEvent(event.Name) {
+			continue
+		}
+		appendSessionsUntil(event.Timestamp)
+		rows = append(rows, ExportRow{
+			Name:  event.Name,
+			Label: EventDisplayName(event.Name),
+			Start: event.Timestamp,
+			Note:  event.Note,
+		})
+	}
+	appendSessionsUntil(until)
+	return rows
+}
+
+// WriteExport encodes the rows in the given format. Times are written in loc.
+func WriteExport(w io.Writer, format string, rows []ExportRow, loc *time.Location) error {
+	switch format {
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		// BOM so that spreadsheets detect UTF-8
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportHeaders()); err != nil {
+			return err
+		}
+		for _, row := range exportTable(rows, loc) {
+			record := make([]string, len(row))
+			for i, value := range row {
+				record[i] = fmt.Sprint(value)
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "xlsx":
+		headers := exportHeaders()
+		table := [][]interface{}{make([]interface{}, len(headers))}
+		for i, header := range headers {
+			table[0][i] = header
+		}
+		table = append(table, exportTable(rows, loc)...)
+		return writeXLSX(w, "BabyCheck", table)
+	}
+	return fmt.Errorf("unsupported export format %q", format)
+}
+
+// exportTable renders the rows as spreadsheet cells, durations in minutes
+func exportTable(rows []ExportRow, loc *time.Location) [][]interface{} {
+	table := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		end, duration, open := "", interface{}(""), ""
+		if row.Session {
+			duration = row.Duration / 60000
+			if row.Open {
+				open = "oui"
+			} else {
+				end = formatExportTime(row.End, loc)
+			}
+		}
+		table = append(table, []interface{}{
+			row.Label,
+			formatExportTime(row.Start, loc),
+			end,
+			duration,
+			open,
+			row.Note,
+		})
+	}
+	return table
+}
+
+func formatExportTime(timestamp int64, loc *time.Location) string {
+	return time.UnixMilli(timestamp).In(loc).Format("2006-01-02 15:04:05")
+}
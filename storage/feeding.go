@@ -0,0 +1,122 @@
+package storage
+
+const (
+	// feedMergeGap is how long after a side stops the other side can start
+	// and still belong to the same feed
+	feedMergeGap = 10 * 60 * 1000
+	// clusterFeedInterval is the maximum time between two feed starts of a
+	// cluster feeding episode
+	clusterFeedInterval = 60 * 60 * 1000
+	// clusterFeedMinFeeds is the number of close feeds making an episode
+	clusterFeedMinFeeds = 3
+	// shortFeedDuration is the side duration under which the breast is
+	// probably not emptied, so the next feed should start on the same side
+	shortFeedDuration = 10 * 60 * 1000
+)
+
+// feedingStats fills the feeding analytics of stats from the breastfeeding
+// sessions. Feeds are counted where they start, like the per-side counts.
+func feedingStats(stats *BabyStats, sessions []Session, start, end int64) {
+	feedStarts := make([]int64, 0)
+	for _, feedStart := range groupFeeds(sessions, end) {
+		if feedStart >= start {
+			feedStarts = append(feedStarts, feedStart)
+		}
+	}
+	var last *Session
+	for i, session := range sessions {
+		if session.Type != "leftBoob" && session.Type != "rightBoob" {
+			continue
+		}
+		if session.Start >= end {
+			break
+		}
+		sessionEnd := session.Start + session.Duration
+		if last == nil || sessionEnd >= last.Start+last.Duration {
+			last = &sessions[i]
+		}
+	}
+
+	feedIntervalStats(stats, feedStarts)
+	sideBalance(stats)
+
+	// Start with the side used last if it was short, the other one otherwise
+	if last != nil {
+		stats.NextSide = last.Type
+		if last.Duration >= shortFeedDuration {
+			stats.NextSide = otherSide(last.Type)
+		}
+	}
+}
+
+// groupFeeds returns the start of the feeds started before end. Sides
+// starting within feedMergeGap of the end of the previous one belong to the
+// same feed.
+func groupFeeds(sessions []Session, end int64) []int64 {
+	feedStarts := make([]int64, 0)
+	var feedEnd int64
+	for _, session := range sessions {
+		if session.Type != "leftBoob" && session.Type != "rightBoob" {
+			continue
+		}
+		if session.Start >= end {
+			break
+		}
+		if feedEnd == 0 || session.Start > feedEnd+feedMergeGap {
+			feedStarts = append(feedStarts, session.Start)
+			feedEnd = 0
+		}
+		if sessionEnd := session.Start + session.Duration; sessionEnd > feedEnd {
+			feedEnd = sessionEnd
+		}
+	}
+	return feedStarts
+}
+
+// feedIntervalStats fills the feed count, the intervals between feed starts
+// and the cluster feeding episodes from the sorted feed starts
+func feedIntervalStats(stats *BabyStats, feedStarts []int64) {
+	stats.FeedCount = len(feedStarts)
+
+	var totalInterval int64
+	clusterSize := 1
+	for i := 1; i < len(feedStarts); i++ {
+		interval := feedStarts[i] - feedStarts[i-1]
+		totalInterval += interval
+		if stats.MinFeedInterval == 0 || interval < stats.MinFeedInterval {
+			stats.MinFeedInterval = interval
+		}
+		if interval > stats.MaxFeedInterval {
+			stats.MaxFeedInterval = interval
+		}
+
+		if interval <= clusterFeedInterval {
+			clusterSize++
+			if clusterSize == clusterFeedMinFeeds {
+				stats.ClusterFeedCount++
+			}
+		} else {
+			clusterSize = 1
+		}
+	}
+	if len(feedStarts) > 1 {
+		stats.AverageFeedInterval = totalInterval / int64(len(feedStarts)-1)
+	}
+}
+
+// sideBalance fills the left breast shares from the per-side totals
+func sideBalance(stats *BabyStats) {
+	if count := stats.LeftBoobCount + stats.RightBoobCount; count > 0 {
+		stats.LeftBoobCountShare = float64(stats.LeftBoobCount) / float64(count)
+	}
+	if duration := stats.LeftBoobDuration + stats.RightBoobDuration; duration > 0 {
+		stats.LeftBoobDurationShare = float64(stats.LeftBoobDuration) / float64(duration)
+	}
+}
+
+func otherSide(side string) string {
+	if side == "leftBoob" {
+		return "rightBoob"
+	}
+	return "leftBoob"
+}
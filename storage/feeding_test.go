@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestFeedingStats(t *testing.T) {
+	minute := int64(60 * 1000)
+	base := int64(1700000000000)
+	at := func(minutes int64) int64 { return base + minutes*minute }
+
+	events := []DBBabyEvent{
+		// Feed 1, switching sides
+		{Timestamp: at(0), Name: "leftBoob"},
+		{Timestamp: at(15), Name: "leftBoobStop"},
+		{Timestamp: at(17), Name: "rightBoob"},
+		{Timestamp: at(27), Name: "rightBoobStop"},
+		// Feeds 2 to 4, a cluster feeding episode
+		{Timestamp: at(180), Name: "leftBoob"},
+		{Timestamp: at(190), Name: "leftBoobStop"},
+		{Timestamp: at(230), Name: "rightBoob"},
+		{Timestamp: at(240), Name: "rightBoobStop"},
+		{Timestamp: at(280), Name: "leftBoob"},
+		{Timestamp: at(285), Name: "leftBoobStop"},
+	}
+	stats := computeStats(events, at(0), at(300), sessionState{})
+
+	if stats.FeedCount != 4 {
+		t.Errorf("Expected 4 feeds, got %d", stats.FeedCount)
+	}
+	if stats.MinFeedInterval != 50*minute || stats.MaxFeedInterval != 180*minute || stats.AverageFeedInterval != 280*minute/3 {
+		t.Errorf("Unexpected feed intervals: min %d, avg %d, max %d", stats.MinFeedInterval, stats.AverageFeedInterval, stats.MaxFeedInterval)
+	}
+	if stats.ClusterFeedCount != 1 {
+		t.Errorf("Expected 1 cluster feeding episode, got %d", stats.ClusterFeedCount)
+	}
+	if stats.LeftBoobCountShare != 0.6 {
+		t.Errorf("Expected left share of 0.6 by count, got %v", stats.LeftBoobCountShare)
+	}
+	if stats.LeftBoobDurationShare != 0.6 {
+		t.Errorf("Expected left share of 0.6 by duration, got %v", stats.LeftBoobDurationShare)
+	}
+	// Last feed was a short one on the left
+	if stats.NextSide != "leftBoob" {
+		t.Errorf("Expected next side to be leftBoob, got %q", stats.NextSide)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// calendarEventNames are the one-off events included in the calendar feed,
+// with the duration given to their VEVENT
+var calendarEventNames = map[string]time.Duration{
+	"vaccine":     30 * time.Minute,
+	"appointment": 30 * time.Minute,
+}
+
+// calendarSessionNames are the summaries of the session VEVENTs
+var calendarSessionNames = map[string]string{
+	"sleep":     "💤 Sommeil",
+	"leftBoob":  "🍼 Allaitement (sein gauche)",
+	"rightBoob": "🍼 Allaitement (sein droit)",
+}
+
+// WriteICS writes an iCalendar feed with one VEVENT per sleep or feeding
+// session, plus vaccination and appointment entries. Open sessions are
+// written with their duration so far.
+func WriteICS(w io.Writer, babyName string, events []DBBabyEvent, now int64) error {
+	var sb strings.Builder
+	line := func(format string, args ...interface{}) {
+		sb.WriteString(foldICSLine(fmt.Sprintf(format, args...)))
+	}
+	stamp := formatICSTime(now)
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//BabyCheck//Calendar//FR")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", escapeICSText("BabyCheck - "+babyName))
+	line("REFRESH-INTERVAL;VALUE=DURATION:PT15M")
+
+	for _, session := range PairSessions(events, now) {
+		summary := calendarSessionNames[session.Type]
+		if session.Open {
+			summary += " (en cours)"
+		}
+		line("BEGIN:VEVENT")
+		line("UID:%s-%d@babycheck", session.Type, session.Start)
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART:%s", formatICSTime(session.Start))
+		line("DURATION:%s", formatICSDuration(time.Duration(session.Duration)*time.Millisecond))
+		line("SUMMARY:%s", escapeICSText(summary))
+		line("END:VEVENT")
+	}
+
+	for _, event := range events {
+		duration, ok := calendarEventNames[event.Name]
+		if !ok {
+			continue
+		}
+		line("BEGIN:VEVENT")
+		line("UID:%s@babycheck", event.ID)
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART:%s", formatICSTime(event.Timestamp))
+		line("DURATION:%s", formatICSDuration(duration))
+		line("SUMMARY:%s", escapeICSText(EventDisplayName(event.Name)))
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func formatICSTime(timestamp int64) string {
+	return time.UnixMilli(timestamp).UTC().Format("20060102T150405Z")
+}
+
+// formatICSDuration formats a duration as an RFC 5545 dur-time, e.g. PT1H5M
+func formatICSDuration(d time.Duration) string {
+	if d < time.Second {
+		return "PT0S"
+	}
+	seconds := int64(d / time.Second)
+	result := "PT"
+	if h := seconds / 3600; h > 0 {
+		result += fmt.Sprintf("%dH", h)
+	}
+	if m := seconds % 3600 / 60; m > 0 {
+		result += fmt.Sprintf("%dM", m)
+	}
+	if s := seconds % 60; s > 0 {
+		result += fmt.Sprintf("%dS", s)
+	}
+	return result
+}
+
+func escapeICSText(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return replacer.Replace(value)
+}
+
+// foldICSLine terminates a content line with CRLF, folding it at 75 octets
+// without splitting UTF-8 sequences
+func foldICSLine(value string) string {
+	var sb strings.Builder
+	lineLength := 0
+	for _, r := range value {
+		size := len(string(r))
+		if lineLength+size > 75 {
+			sb.WriteString("\r\n ")
+			lineLength = 1
+		}
+		sb.WriteRune(r)
+		lineLength += size
+	}
+	sb.WriteString("\r\n")
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteICS(t *testing.T) {
+	baseTime := time.Date(2024, 3, 1, 21, 0, 0, 0, time.UTC).UnixMilli()
+	minute := int64(60 * 1000)
+	events := []DBBabyEvent{
+		{ID: "1", Timestamp: baseTime, Name: "sleep"},
+		{ID: "2", Timestamp: baseTime + 95*minute, Name: "wake"},
+		{ID: "3", Timestamp: baseTime + 100*minute, Name: "pee"},
+		{ID: "4", Timestamp: baseTime + 24*60*minute, Name: "vaccine"},
+	}
+
+	var sb strings.Builder
+	err := WriteICS(&sb, "Léa", events, baseTime+120*minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ics := sb.String()
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"DTSTART:20240301T210000Z\r\n",
+		"DURATION:PT1H35M\r\n",
+		"UID:4@babycheck\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, expected) {
+			t.Errorf("Expected feed to contain %q", expected)
+		}
+	}
+	if strings.Count(ics, "BEGIN:VEVENT") != 2 {
+		t.Errorf("Expected 2 VEVENTs (sleep and vaccine), got %d", strings.Count(ics, "BEGIN:VEVENT"))
+	}
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line longer than 75 octets: %q", line)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ImportMapping describes how the columns of a CSV export from another
+// tracker map onto babycheck events. Column names and values are matched
+// case-insensitively.
+type ImportMapping struct {
+	TimeColumn   string            `json:"time_column"`   // Start of the row (required)
+	EndColumn    string            `json:"end_column"`    // End of a feed or sleep session (optional)
+	TypeColumn   string            `json:"type_column"`   // Row kind, mapped through Types (required)
+	DetailColumn string            `json:"detail_column"` // Breast side or diaper content (optional)
+	TimeFormats  []string          `json:"time_formats"`  // Go layouts, tried in order
+	Delimiter    string            `json:"delimiter"`     // Defaults to ","
+	Types        map[string]string `json:"types"`         // Type value -> "feed", "sleep" or "diaper"
+	Feeds        map[string]string `json:"feeds"`         // Detail value -> "leftBoob" or "rightBoob"
+	Diapers      map[string]string `json:"diapers"`       // Detail value -> "pee", "poop" or "pee+poop"
+}
+
+// ImportPresets holds the column mappings of the trackers parents usually
+// come from. "generic" expects type, start, end and detail columns.
+var ImportPresets = map[string]ImportMapping{
+	"generic": {
+		TimeColumn:   "start",
+		EndColumn:    "end",
+		TypeColumn:   "type",
+		DetailColumn: "detail",
+		Types: map[string]string{
+			"feed":   "feed",
+			"sleep":  "sleep",
+			"diaper": "diaper",
+		},
+		Feeds: map[string]string{
+			"left":  "leftBoob",
+			"right": "rightBoob",
+		},
+		Diapers: map[string]string{
+			"pee":  "pee",
+			"poop": "poop",
+			"both": "pee+poop",
+		},
+	},
+	"huckleberry": {
+		TimeColumn:   "Start",
+		EndColumn:    "End",
+		TypeColumn:   "Type",
+		DetailColumn: "Start Condition",
+		TimeFormats:  []string{"2006-01-02 15:04", "2006-01-02 15:04:05"},
+		Types: map[string]string{
+			"feed":   "feed",
+			"sleep":  "sleep",
+			"diaper": "diaper",
+		},
+		Feeds: map[string]string{
+			"left":  "leftBoob",
+			"l":     "leftBoob",
+			"right": "rightBoob",
+			"r":     "rightBoob",
+		},
+		Diapers: map[string]string{
+			"pee":  "pee",
+			"poo":  "poop",
+			"both": "pee+poop",
+		},
+	},
+}
+
+var defaultImportTimeFormats = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+}
+
+// ImportRow is the outcome of one CSV line
+type ImportRow struct {
+	Line      int           `json:"line"`
+	Events    []DBBabyEvent `json:"events,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Duplicate bool          `json:"duplicate,omitempty"`
+}
+
+// ImportResult summarizes an import, or what an import would do in dry-run
+type ImportResult struct {
+	DryRun     bool        `json:"dry_run"`
+	Rows       []ImportRow `json:"rows"`
+	Imported   int         `json:"imported"`   // Number of events inserted
+	Duplicates int         `json:"duplicates"` // Number of rows already present
+	Errors     int         `json:"errors"`     // Number of rows that could not be mapped
+}
+
+// ParseImportCSV maps every line of a CSV export onto babycheck events.
+// Lines that cannot be mapped are reported with an error instead of
+// aborting the whole file. Times without an offset are read in loc.
+func ParseImportCSV(r io.Reader, mapping ImportMapping, loc *time.Location) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if mapping.Delimiter != "" {
+		reader.Comma = []rune(mapping.Delimiter)[0]
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// Strip the BOM spreadsheets like to add
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	timeIdx, ok := columns[strings.ToLower(mapping.TimeColumn)]
+	if !ok {
+		return nil, fmt.Errorf("time column %q not found", mapping.TimeColumn)
+	}
+	typeIdx, ok := columns[strings.ToLower(mapping.TypeColumn)]
+	if !ok {
+		return nil, fmt.Errorf("type column %q not found", mapping.TypeColumn)
+	}
+	endIdx, hasEnd := columns[strings.ToLower(mapping.EndColumn)]
+	detailIdx, hasDetail := columns[strings.ToLower(mapping.DetailColumn)]
+
+	formats := mapping.TimeFormats
+	if len(formats) == 0 {
+		formats = defaultImportTimeFormats
+	}
+	field := func(record []string, idx int) string {
+		if idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	rows := make([]ImportRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var row ImportRow
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				row.Line = parseErr.StartLine
+			}
+			row.Error = err.Error()
+			rows = append(rows, row)
+			continue
+		}
+		// Quoted fields may span several lines, the record starts at the
+		// line of its first field
+		row.Line, _ = reader.FieldPos(0)
+
+		start, err := parseImportTime(field(record, timeIdx), formats, loc)
+		if err != nil {
+			row.Error = err.Error()
+			rows = append(rows, row)
+			continue
+		}
+		var end int64
+		if hasEnd && field(record, endIdx) != "" {
+			end, err = parseImportTime(field(record, endIdx), formats, loc)
+			if err != nil {
+				row.Error = err.Error()
+				rows = append(rows, row)
+				continue
+			}
+			if end < start {
+				row.Error = "end is before start"
+				rows = append(rows, row)
+				continue
+			}
+		}
+		detail := ""
+		if hasDetail {
+			detail = field(record, detailIdx)
+		}
+
+		rawType := field(record, typeIdx)
+		kind := lookupFold(mapping.Types, rawType)
+		switch kind {
+		case "sleep":
+			row.Events = append(row.Events, DBBabyEvent{Timestamp: start, Name: "sleep"})
+			if end != 0 {
+				row.Events = append(row.Events, DBBabyEvent{Timestamp: end, Name: "wake"})
+			}
+		case "feed":
+			side := lookupFold(mapping.Feeds, detail)
+			if side != "leftBoob" && side != "rightBoob" {
+				row.Error = fmt.Sprintf("unknown feeding side %q", detail)
+				break
+			}
+			row.Events = append(row.Events, DBBabyEvent{Timestamp: start, Name: side})
+			if end != 0 {
+				row.Events = append(row.Events, DBBabyEvent{Timestamp: end, Name: side + "Stop"})
+			}
+		case "diaper":
+			content := lookupFold(mapping.Diapers, detail)
+			if content == "" {
+				row.Error = fmt.Sprintf("unknown diaper content %q", detail)
+				break
+			}
+			// A mixed diaper gives one event per content, a millisecond
+			// apart since events are found by their timestamp
+			for i, name := range strings.Split(content, "+") {
+				row.Events = append(row.Events, DBBabyEvent{Timestamp: start + int64(i), Name: name})
+			}
+		default:
+			row.Error = fmt.Sprintf("unsupported row type %q", rawType)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseImportTime(value string, formats []string, loc *time.Location) (int64, error) {
+	if value == "" {
+		return 0, fmt.Errorf("missing time")
+	}
+	for _, layout := range formats {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t.UnixMilli(), nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized time %q", value)
+}
+
+func lookupFold(values map[string]string, key string) string {
+	for k, v := range values {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// Import inserts the parsed rows as imported events, skipping rows whose events
+// already exist (same name at the same timestamp) in storage or earlier in
+// the file. With dryRun nothing is written.
+func (s *Storage) Import(rows []ImportRow, dryRun bool) *ImportResult {
+	result := &ImportResult{
+		DryRun: dryRun,
+		Rows:   rows,
+	}
+
+	var first, last int64
+	for _, row := range rows {
+		for _, event := range row.Events {
+			if first == 0 || event.Timestamp < first {
+				first = event.Timestamp
+			}
+			if event.Timestamp > last {
+				last = event.Timestamp
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	if first != 0 {
+		for _, event := range s.Search(first, last) {
+			seen[importKey(event)] = true
+		}
+	}
+
+	for i := range result.Rows {
+		row := &result.Rows[i]
+		if row.Error != "" {
+			result.Errors++
+			continue
+		}
+		duplicate := true
+		for _, event := range row.Events {
+			if !seen[importKey(event)] {
+				duplicate = false
+			}
+		}
+		if duplicate {
+			row.Duplicate = true
+			result.Duplicates++
+			continue
+		}
+		for _, event := range row.Events {
+			if seen[importKey(event)] {
+				continue
+			}
+			seen[importKey(event)] = true
+			if dryRun {
+				result.Imported++
+				continue
+			}
+			event.Source = SourceImported
+			if _, err := s.SaveEvent(event); err == nil {
+				result.Imported++
+			}
+		}
+	}
+
+	fmt.Printf("Import (dry run: %v): %d events, %d duplicates, %d errors\n", dryRun, result.Imported, result.Duplicates, result.Errors)
+	return result
+}
+
+func importKey(event DBBabyEvent) string {
+	return fmt.Sprintf("%d:%s", event.Timestamp, event.Name)
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	csvData := `Type,Start,End,Detail
+Sleep,2024-03-01 21:00,2024-03-01 23:30,
+Feed,2024-03-01 23:35,2024-03-01 23:50,Left
+Diaper,2024-03-01 23:55,,Both
+Bottle,2024-03-02 02:00,,
+Feed,2024-03-02 03:00,,Middle
+Sleep,not a date,,
+`
+	rows, err := ParseImportCSV(strings.NewReader(csvData), ImportPresets["generic"], time.UTC)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rows) != 6 {
+		t.Fatalf("Expected 6 rows, got %d", len(rows))
+	}
+
+	sleepStart := time.Date(2024, 3, 1, 21, 0, 0, 0, time.UTC).UnixMilli()
+	if len(rows[0].Events) != 2 || rows[0].Events[0].Name != "sleep" || rows[0].Events[0].Timestamp != sleepStart || rows[0].Events[1].Name != "wake" {
+		t.Errorf("Expected sleep/wake pair for sleep row, got %+v", rows[0].Events)
+	}
+	if len(rows[1].Events) != 2 || rows[1].Events[0].Name != "leftBoob" || rows[1].Events[1].Name != "leftBoobStop" {
+		t.Errorf("Expected left feed pair, got %+v", rows[1].Events)
+	}
+	if len(rows[2].Events) != 2 || rows[2].Events[0].Name != "pee" || rows[2].Events[1].Name != "poop" {
+		t.Errorf("Expected pee and poop for mixed diaper, got %+v", rows[2].Events)
+	} else if rows[2].Events[1].Timestamp != rows[2].Events[0].Timestamp+1 {
+		t.Errorf("Expected poop a millisecond after pee, got %+v", rows[2].Events)
+	}
+	for i, line := range []int{5, 6, 7} {
+		row := rows[3+i]
+		if row.Error == "" {
+			t.Errorf("Expected an error for line %d", line)
+		}
+		if row.Line != line {
+			t.Errorf("Expected line %d, got %d", line, row.Line)
+		}
+	}
+}
+
+func TestParseImportCSVMultilineField(t *testing.T) {
+	csvData := `Type,Start,End,Start Condition,Notes
+Sleep,2024-03-01 21:00,2024-03-01 23:30,,"Long nap
+woke up crying
+twice"
+Feed,not a date,,Left,
+Diaper,2024-03-01 23:55,,Both,
+`
+	rows, err := ParseImportCSV(strings.NewReader(csvData), ImportPresets["huckleberry"], time.UTC)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+	for i, line := range []int{2, 5, 6} {
+		if rows[i].Line != line {
+			t.Errorf("Row %d: expected line %d, got %d", i, line, rows[i].Line)
+		}
+	}
+	if rows[1].Error == "" {
+		t.Errorf("Expected an error for line 5")
+	}
+}
+
+func TestImportMixedDiaperEditable(t *testing.T) {
+	s := newTestStorage(t)
+	rows, err := ParseImportCSV(strings.NewReader("Type,Start,End,Detail\nDiaper,2024-03-01 23:55,,Both\n"), ImportPresets["generic"], time.UTC)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result := s.Import(rows, false); result.Imported != 2 {
+		t.Fatalf("Expected 2 imported events, got %+v", result)
+	}
+
+	// Each of the two events can be reached and edited on its own: swap
+	// their contents
+	swapped := map[string]string{"pee": "poop", "poop": "pee"}
+	for _, event := range rows[0].Events {
+		updated, err := s.UpdateEvent(event.Timestamp, swapped[event.Name], 1)
+		if err != nil {
+			t.Fatalf("Failed to edit the %s event: %v", event.Name, err)
+		}
+		if updated.Timestamp != event.Timestamp || updated.Name != swapped[event.Name] || updated.Revision != 2 {
+			t.Errorf("Expected the %s event edited, got %+v", event.Name, updated)
+		}
+	}
+	events := s.Search(rows[0].Events[0].Timestamp, rows[0].Events[1].Timestamp)
+	if len(events) != 2 || events[0].Name != "poop" || events[1].Name != "pee" {
+		t.Errorf("Expected both events edited, got %+v", events)
+	}
+}
+
+func TestParseImportCSVMissingColumn(t *testing.T) {
+	_, err := ParseImportCSV(strings.NewReader("When,What\n"), ImportPresets["generic"], time.UTC)
+	if err == nil {
+		t.Error("Expected an error when the time column is missing")
+	}
+}
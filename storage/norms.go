@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Norm is the reference range of a daily metric for an age, in days of life
+// (the birth date is day 1)
+type Norm struct {
+	Metric  string  `json:"metric"` // "pee", "poop", "feeds" or "sleep_hours"
+	FromDay int     `json:"from_day"`
+	ToDay   int     `json:"to_day"` // Inclusive, 0 for no upper bound
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max,omitempty"` // 0 when only the minimum matters
+}
+
+// AgeNorms are the bundled reference ranges, from the usual guidance given
+// to parents of breastfed newborns. Wet and dirty diapers go up by one a day
+// until day 6, feeds are expected 8 to 12 times a day during the first month.
+var AgeNorms = []Norm{
+	{Metric: "pee", FromDay: 1, ToDay: 1, Min: 1},
+	{Metric: "pee", FromDay: 2, ToDay: 2, Min: 2},
+	{Metric: "pee", FromDay: 3, ToDay: 3, Min: 3},
+	{Metric: "pee", FromDay: 4, ToDay: 4, Min: 4},
+	{Metric: "pee", FromDay: 5, ToDay: 5, Min: 5},
+	{Metric: "pee", FromDay: 6, ToDay: 42, Min: 6},
+	{Metric: "poop", FromDay: 1, ToDay: 1, Min: 1},
+	{Metric: "poop", FromDay: 2, ToDay: 2, Min: 2},
+	{Metric: "poop", FromDay: 3, ToDay: 42, Min: 3},
+	{Metric: "feeds", FromDay: 1, ToDay: 28, Min: 8, Max: 12},
+	{Metric: "feeds", FromDay: 29, ToDay: 90, Min: 7, Max: 10},
+	{Metric: "sleep_hours", FromDay: 1, ToDay: 90, Min: 14, Max: 17},
+	{Metric: "sleep_hours", FromDay: 91, ToDay: 365, Min: 12, Max: 15},
+}
+
+var normLabels = map[string]string{
+	"pee":         "couches mouillées",
+	"poop":        "couches sales",
+	"feeds":       "tétées",
+	"sleep_hours": "heures de sommeil",
+}
+
+// Alert is a day whose metric is outside its reference range
+type Alert struct {
+	Date      string  `json:"date"` // YYYY-MM-DD
+	DayOfLife int     `json:"day_of_life"`
+	Metric    string  `json:"metric"`
+	Value     float64 `json:"value"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max,omitempty"`
+	Level     string  `json:"level"` // "low" or "high"
+	Message   string  `json:"message"`
+}
+
+// DayOfLife returns the day of life of date, 1 being the birth date. Both
+// are YYYY-MM-DD days.
+func DayOfLife(birthDate, date string) (int, error) {
+	birth, err := time.Parse("2006-01-02", birthDate)
+	if err != nil {
+		return 0, err
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(day.Sub(birth).Hours()/24)) + 1, nil
+}
+
+// CheckNorms compares the stats of a day with the norms of its day of life
+func CheckNorms(stats *BabyStats, date string, dayOfLife int) []Alert {
+	values := map[string]float64{
+		"pee":         float64(stats.PeeCount),
+		"poop":        float64(stats.PoopCount),
+		"feeds":       float64(stats.FeedCount),
+		"sleep_hours": math.Round(float64(stats.SleepTime)/float64(time.Hour/time.Millisecond)*10) / 10,
+	}
+
+	alerts := make([]Alert, 0)
+	for _, norm := range AgeNorms {
+		if dayOfLife < norm.FromDay || (norm.ToDay != 0 && dayOfLife > norm.ToDay) {
+			continue
+		}
+		value := values[norm.Metric]
+		alert := Alert{
+			Date:      date,
+			DayOfLife: dayOfLife,
+			Metric:    norm.Metric,
+			Value:     value,
+			Min:       norm.Min,
+			Max:       norm.Max,
+		}
+		switch {
+		case value < norm.Min:
+			alert.Level = "low"
+			alert.Message = fmt.Sprintf("Seulement %g %s au jour %d (minimum %g)", value, normLabels[norm.Metric], dayOfLife, norm.Min)
+		case norm.Max != 0 && value > norm.Max:
+			alert.Level = "high"
+			alert.Message = fmt.Sprintf("%g %s au jour %d (maximum %g)", value, normLabels[norm.Metric], dayOfLife, norm.Max)
+		default:
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// AlertDayStartHour is the local hour at which the days checked against the
+// norms start, so that a night sleep falls within a single day
+const AlertDayStartHour = 7
+
+// alertDay is a complete day checked against the norms, dated by its start
+type alertDay struct {
+	Date       string // YYYY-MM-DD
+	Start, End int64  // First and last millisecond
+}
+
+// alertDays returns the given number of complete days in loc before now,
+// oldest first. Days start at dayStartHour, a day is only complete once the
+// next one has started.
+func alertDays(now time.Time, days, dayStartHour int, loc *time.Location) []alertDay {
+	current := now.In(loc)
+	if current.Hour() < dayStartHour {
+		current = current.AddDate(0, 0, -1)
+	}
+	current = AtHour(current, dayStartHour)
+
+	result := make([]alertDay, 0, days)
+	for i := days; i >= 1; i-- {
+		start := AtHour(current.AddDate(0, 0, -i), dayStartHour)
+		end := AtHour(current.AddDate(0, 0, -i+1), dayStartHour)
+		result = append(result, alertDay{
+			Date:  start.Format("2006-01-02"),
+			Start: start.UnixMilli(),
+			End:   end.UnixMilli() - 1,
+		})
+	}
+	return result
+}
+
+// checkDays compares the stats of each day with the norms of its day of
+// life, skipping the days before birth
+func checkDays(birthDate string, days []alertDay, stats func(start, end int64) *BabyStats) ([]Alert, error) {
+	alerts := make([]Alert, 0)
+	for _, day := range days {
+		dayOfLife, err := DayOfLife(birthDate, day.Date)
+		if err != nil {
+			return nil, err
+		}
+		if dayOfLife < 1 {
+			continue
+		}
+		alerts = append(alerts, CheckNorms(stats(day.Start, day.End), day.Date, dayOfLife)...)
+	}
+	return alerts, nil
+}
+
+// CalculateAlerts checks the given number of complete local days before
+// now against the norms, skipping the days before birth. Days start at
+// dayStartHour.
+func (s *Storage) CalculateAlerts(birthDate string, days, dayStartHour int, now time.Time) ([]Alert, error) {
+	return checkDays(birthDate, alertDays(now, days, dayStartHour, s.loc), s.CalculateStats)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDayOfLife(t *testing.T) {
+	day, err := DayOfLife("2024-03-28", "2024-04-01")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if day != 5 {
+		t.Errorf("Expected day 5, got %d", day)
+	}
+}
+
+func TestCheckNorms(t *testing.T) {
+	stats := &BabyStats{
+		PeeCount:  3,
+		PoopCount: 3,
+		FeedCount: 13,
+		SleepTime: int64(15 * time.Hour / time.Millisecond),
+	}
+
+	alerts := CheckNorms(stats, "2024-04-01", 5)
+
+	if len(alerts) != 2 {
+		t.Fatalf("Expected 2 alerts, got %d: %+v", len(alerts), alerts)
+	}
+	if alerts[0].Metric != "pee" || alerts[0].Level != "low" || alerts[0].Message != "Seulement 3 couches mouillées au jour 5 (minimum 5)" {
+		t.Errorf("Unexpected wet diaper alert: %+v", alerts[0])
+	}
+	if alerts[1].Metric != "feeds" || alerts[1].Level != "high" {
+		t.Errorf("Expected too many feeds, got %+v", alerts[1])
+	}
+
+	// No diaper norms after 6 weeks
+	if alerts := CheckNorms(&BabyStats{FeedCount: 8, SleepTime: stats.SleepTime}, "2024-06-01", 60); len(alerts) != 0 {
+		t.Errorf("Expected no alert at 2 months, got %+v", alerts)
+	}
+}
+
+func TestCheckDaysNightSleep(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	at := func(day, hour, minute int) int64 {
+		return time.Date(2024, 4, day, hour, minute, 0, 0, paris).UnixMilli()
+	}
+	// A night sleep across midnight, naps during the day and a sleep going
+	// on when the check runs
+	events := []DBBabyEvent{
+		{Timestamp: at(1, 9, 0), Name: "sleep"},
+		{Timestamp: at(1, 12, 0), Name: "wake"},
+		{Timestamp: at(1, 14, 0), Name: "sleep"},
+		{Timestamp: at(1, 17, 0), Name: "wake"},
+		{Timestamp: at(1, 21, 0), Name: "sleep"},
+		{Timestamp: at(2, 6, 30), Name: "wake"},
+		{Timestamp: at(2, 7, 30), Name: "sleep"},
+	}
+	stats := func(start, end int64) *BabyStats {
+		inRange := make([]DBBabyEvent, 0)
+		for _, event := range events {
+			if event.Timestamp >= start && event.Timestamp <= end {
+				inRange = append(inRange, event)
+			}
+		}
+		return computeStats(inRange, start, end, sessionState{})
+	}
+
+	now := time.Date(2024, 4, 2, 8, 0, 0, 0, paris)
+	days := alertDays(now, 1, AlertDayStartHour, paris)
+	if len(days) != 1 || days[0].Date != "2024-04-01" || days[0].Start != at(1, 7, 0) || days[0].End != at(2, 7, 0)-1 {
+		t.Fatalf("Expected the day from 7am to 7am, got %+v", days)
+	}
+	alerts, err := checkDays("2024-03-01", days, stats)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// 3 + 3 + 9.5 hours of sleep, within the norm
+	for _, alert := range alerts {
+		if alert.Metric == "sleep_hours" {
+			t.Errorf("Expected the whole night counted, got %+v", alert)
+		}
+	}
+
+	// Before the day start hour the previous day isn't complete yet
+	early := alertDays(time.Date(2024, 4, 2, 6, 0, 0, 0, paris), 1, AlertDayStartHour, paris)
+	if early[0].Date != "2024-03-31" {
+		t.Errorf("Expected the last complete day to be March 31st, got %+v", early)
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventNotification is published on every event write, so every server
+// instance can push it to the clients of the user
+type EventNotification struct {
+	Type              string       `json:"type"` // "created", "updated" or "deleted"
+	Event             *DBBabyEvent `json:"event"`
+	PreviousTimestamp int64        `json:"previous_timestamp,omitempty"` // Timestamp before an update, when it moved
+	At                int64        `json:"at"`
+}
+
+// notificationsChannel returns the pub/sub channel of the user's events
+func (s *Storage) notificationsChannel() string {
+	return s.eventsKey() + ":notifications"
+}
+
+// publish notifies the subscribers of an event write. Failures are only
+// logged, the write itself succeeded.
+func (s *Storage) publish(kind string, event *DBBabyEvent, previousTimestamp int64) {
+	notification := EventNotification{
+		Type:  kind,
+		Event: event,
+		At:    time.Now().UnixMilli(),
+	}
+	if previousTimestamp != event.Timestamp {
+		notification.PreviousTimestamp = previousTimestamp
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return
+	}
+	if err := s.redis.Publish(s.ctx, s.notificationsChannel(), string(data)).Err(); err != nil {
+		fmt.Printf("Failed to publish %s notification: %v\n", kind, err)
+	}
+}
+
+// SubscribeEvents returns the notifications of the user's event writes until
+// ctx is done, when the channel is closed
+func (s *Storage) SubscribeEvents(ctx context.Context) (<-chan EventNotification, error) {
+	pubsub := s.redis.Subscribe(ctx, s.notificationsChannel())
+	// Wait for the subscription so no write is missed after returning
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	notifications := make(chan EventNotification)
+	go func() {
+		defer close(notifications)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var notification EventNotification
+				if err := json.Unmarshal([]byte(message.Payload), &notification); err != nil {
+					continue
+				}
+				select {
+				case notifications <- notification:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return notifications, nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// PeriodRequest describes the range of a stats query: a rolling period
+// anchored at now, an explicit start/end, or a calendar period
+type PeriodRequest struct {
+	Period       string `json:"period"`         // hour, day, days2, week, thisweek, range, calendar_day, iso_week or month
+	Start        int64  `json:"start"`          // Explicit range start in milliseconds, for "range"
+	End          int64  `json:"end"`            // Explicit range end in milliseconds, for "range"
+	Date         string `json:"date"`           // 2024-03-01, 2024-W09 or 2024-03 for calendar periods, defaults to the current one
+	DayStartHour int    `json:"day_start_hour"` // Hour at which baby days start, e.g. 7
+	Compare      bool   `json:"compare"`        // Also compute the previous equivalent period
+}
+
+// ResolvedPeriod is the range a PeriodRequest covers, and the previous
+// equivalent range to compare it with
+type ResolvedPeriod struct {
+	Start         int64
+	End           int64
+	PreviousStart int64
+	PreviousEnd   int64
+}
+
+// ResolvePeriod turns a period request into timestamps. Calendar periods use
+// loc and start at DayStartHour. Ongoing periods end at now, and their
+// previous period covers the same elapsed time, so that "this week so far"
+// is compared with the same part of last week.
+func ResolvePeriod(req PeriodRequest, now time.Time, loc *time.Location) (*ResolvedPeriod, error) {
+	if req.DayStartHour < 0 || req.DayStartHour > 23 {
+		return nil, fmt.Errorf("day_start_hour must be between 0 and 23")
+	}
+	nowMs := now.UnixMilli()
+
+	// Rolling periods are shifted back by their own length
+	rolling := func(length time.Duration) *ResolvedPeriod {
+		start := nowMs - length.Milliseconds()
+		return &ResolvedPeriod{
+			Start:         start,
+			End:           nowMs,
+			PreviousStart: start - length.Milliseconds(),
+			PreviousEnd:   start,
+		}
+	}
+	// Calendar periods are shifted with AddDate so that DST days and months
+	// of different lengths line up
+	calendar := func(first time.Time, years, months, days int) *ResolvedPeriod {
+		start := AtHour(first, req.DayStartHour)
+		end := AtHour(first.AddDate(years, months, days), req.DayStartHour)
+		previous := AtHour(first.AddDate(-years, -months, -days), req.DayStartHour)
+		period := &ResolvedPeriod{
+			Start:         start.UnixMilli(),
+			End:           end.UnixMilli(),
+			PreviousStart: previous.UnixMilli(),
+			PreviousEnd:   start.UnixMilli(),
+		}
+		if period.End > nowMs {
+			period.End = nowMs
+			period.PreviousEnd = period.PreviousStart + (nowMs - period.Start)
+		}
+		return period
+	}
+	// The current baby day starts at DayStartHour, so before that hour we
+	// are still in the previous calendar day
+	current := now.In(loc)
+	if current.Hour() < req.DayStartHour {
+		current = current.AddDate(0, 0, -1)
+	}
+
+	switch req.Period {
+	case "hour":
+		return rolling(time.Hour), nil
+	case "days2":
+		return rolling(48 * time.Hour), nil
+	case "week":
+		return rolling(7 * 24 * time.Hour), nil
+	case "thisweek":
+		return calendar(StartOfWeek(current, loc), 0, 0, 7), nil
+	case "range":
+		if req.End <= req.Start {
+			return nil, fmt.Errorf("end must be after start")
+		}
+		length := req.End - req.Start
+		return &ResolvedPeriod{
+			Start:         req.Start,
+			End:           req.End,
+			PreviousStart: req.Start - length,
+			PreviousEnd:   req.Start,
+		}, nil
+	case "calendar_day":
+		first := StartOfDay(current, loc)
+		if req.Date != "" {
+			day, err := time.ParseInLocation("2006-01-02", req.Date, loc)
+			if err != nil {
+				return nil, fmt.Errorf("date must be YYYY-MM-DD")
+			}
+			first = day
+		}
+		return calendar(first, 0, 0, 1), nil
+	case "iso_week":
+		year, week := current.ISOWeek()
+		if req.Date != "" {
+			if _, err := fmt.Sscanf(req.Date, "%d-W%d", &year, &week); err != nil || week < 1 || week > 53 {
+				return nil, fmt.Errorf("date must be YYYY-Www")
+			}
+		}
+		return calendar(isoWeekStart(year, week, loc), 0, 0, 7), nil
+	case "month":
+		first := StartOfMonth(current, loc)
+		if req.Date != "" {
+			month, err := time.ParseInLocation("2006-01", req.Date, loc)
+			if err != nil {
+				return nil, fmt.Errorf("date must be YYYY-MM")
+			}
+			first = month
+		}
+		return calendar(first, 0, 1, 0), nil
+	}
+	// "day" and unknown periods default to the last 24 hours
+	return rolling(24 * time.Hour), nil
+}
+
+// isoWeekStart returns local midnight of the Monday of an ISO 8601 week.
+// January 4th always belongs to week 1.
+func isoWeekStart(year, week int, loc *time.Location) time.Time {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, loc)
+	offset := (int(jan4.Weekday()) + 6) % 7 // Days since Monday
+	return jan4.AddDate(0, 0, -offset+(week-1)*7)
+}
+
+// PeriodStats is a BabyStats with optional comparison to the previous
+// equivalent period. Without comparison it serializes like BabyStats.
+type PeriodStats struct {
+	*BabyStats
+	Previous *BabyStats `json:"previous,omitempty"`
+	Delta    *BabyStats `json:"delta,omitempty"` // Current minus previous
+}
+
+// DiffStats returns the difference current - previous of every metric. The
+// period bounds are those of the current period.
+func DiffStats(current, previous *BabyStats) *BabyStats {
+	return &BabyStats{
+		SleepTime:             current.SleepTime - previous.SleepTime,
+		SleepCount:            current.SleepCount - previous.SleepCount,
+		AverageSleepTime:      current.AverageSleepTime - previous.AverageSleepTime,
+		LeftBoobCount:         current.LeftBoobCount - previous.LeftBoobCount,
+		RightBoobCount:        current.RightBoobCount - previous.RightBoobCount,
+		LeftBoobDuration:      current.LeftBoobDuration - previous.LeftBoobDuration,
+		RightBoobDuration:     current.RightBoobDuration - previous.RightBoobDuration,
+		FeedCount:             current.FeedCount - previous.FeedCount,
+		MinFeedInterval:       current.MinFeedInterval - previous.MinFeedInterval,
+		AverageFeedInterval:   current.AverageFeedInterval - previous.AverageFeedInterval,
+		MaxFeedInterval:       current.MaxFeedInterval - previous.MaxFeedInterval,
+		ClusterFeedCount:      current.ClusterFeedCount - previous.ClusterFeedCount,
+		LeftBoobCountShare:    current.LeftBoobCountShare - previous.LeftBoobCountShare,
+		LeftBoobDurationShare: current.LeftBoobDurationShare - previous.LeftBoobDurationShare,
+		PeeCount:              current.PeeCount - previous.PeeCount,
+		PoopCount:             current.PoopCount - previous.PoopCount,
+		PeriodStart:           current.PeriodStart,
+		PeriodEnd:             current.PeriodEnd,
+	}
+}
+
+// CalculatePeriodStats computes the stats of a resolved period and, when
+// compare is set, of its previous equivalent period
+func (s *Storage) CalculatePeriodStats(period *ResolvedPeriod, compare bool) *PeriodStats {
+	result := &PeriodStats{
+		BabyStats: s.CalculateStats(period.Start, period.End),
+	}
+	if compare {
+		result.Previous = s.CalculateStats(period.PreviousStart, period.PreviousEnd)
+		result.Delta = DiffStats(result.BabyStats, result.Previous)
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolvePeriod(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	ms := func(t time.Time) int64 { return t.UnixMilli() }
+	now := time.Date(2024, 3, 14, 5, 0, 0, 0, paris) // Thursday, 5am
+
+	t.Run("ISO week", func(t *testing.T) {
+		period, err := ResolvePeriod(PeriodRequest{Period: "iso_week", Date: "2024-W01"}, now, paris)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if period.Start != ms(time.Date(2024, 1, 1, 0, 0, 0, 0, paris)) {
+			t.Errorf("Expected week 1 of 2024 to start on Monday January 1st, got %v", time.UnixMilli(period.Start).In(paris))
+		}
+		if period.PreviousStart != ms(time.Date(2023, 12, 25, 0, 0, 0, 0, paris)) {
+			t.Errorf("Expected previous week to start on December 25th, got %v", time.UnixMilli(period.PreviousStart).In(paris))
+		}
+	})
+
+	t.Run("Day starting at 7am", func(t *testing.T) {
+		// At 5am the baby day is still the one that started yesterday at 7am
+		period, err := ResolvePeriod(PeriodRequest{Period: "calendar_day", DayStartHour: 7}, now, paris)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if period.Start != ms(time.Date(2024, 3, 13, 7, 0, 0, 0, paris)) {
+			t.Errorf("Expected day to start yesterday at 7am, got %v", time.UnixMilli(period.Start).In(paris))
+		}
+		if period.End != ms(now) {
+			t.Errorf("Expected ongoing day to end now, got %v", time.UnixMilli(period.End).In(paris))
+		}
+		// Previous day covers the same 22 hours
+		if period.PreviousEnd-period.PreviousStart != period.End-period.Start {
+			t.Errorf("Expected previous period to cover the same elapsed time")
+		}
+	})
+
+	t.Run("Month across DST", func(t *testing.T) {
+		period, err := ResolvePeriod(PeriodRequest{Period: "month", Date: "2024-03"}, time.Date(2024, 5, 1, 12, 0, 0, 0, paris), paris)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if period.End != ms(time.Date(2024, 4, 1, 0, 0, 0, 0, paris)) {
+			t.Errorf("Expected March to end at local midnight on April 1st, got %v", time.UnixMilli(period.End).In(paris))
+		}
+		if period.PreviousStart != ms(time.Date(2024, 2, 1, 0, 0, 0, 0, paris)) {
+			t.Errorf("Expected previous period to be February, got %v", time.UnixMilli(period.PreviousStart).In(paris))
+		}
+	})
+
+	t.Run("Invalid explicit range", func(t *testing.T) {
+		_, err := ResolvePeriod(PeriodRequest{Period: "range", Start: 2000, End: 1000}, now, paris)
+		if err == nil {
+			t.Error("Expected an error when end is before start")
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// predictionDays is how much history the predictions are based on
+	predictionDays = 7
+	// predictionMinSamples is the number of past intervals needed for a
+	// prediction
+	predictionMinSamples = 3
+	// timeOfDayWindow is how close in local time of day a past interval must
+	// have started to be used, when there are enough of them
+	timeOfDayWindow = 3 * time.Hour
+)
+
+// Prediction is an expected time with a confidence range, from the 25th
+// to the 75th percentile of the past intervals
+type Prediction struct {
+	Expected int64 `json:"expected"`
+	Earliest int64 `json:"earliest"`
+	Latest   int64 `json:"latest"`
+	Samples  int   `json:"samples"` // Number of past intervals used
+}
+
+// Predictions are the next expected feed and nap. A prediction is nil when
+// there isn't enough history, and the nap one also while sleeping.
+type Predictions struct {
+	NextFeed *Prediction `json:"next_feed"`
+	NextNap  *Prediction `json:"next_nap"`
+}
+
+// Predict estimates the next feed and nap from the last predictionDays of
+// events
+func (s *Storage) Predict(now int64) *Predictions {
+	events := s.Search(now-predictionDays*24*60*60*1000, now)
+	return PredictNext(events, now, s.loc)
+}
+
+// PredictNext estimates the next feed from the intervals between feed
+// starts, and the next nap from the wake windows. Past intervals which
+// started around the same time of day as the current one are preferred.
+func PredictNext(events []DBBabyEvent, now int64, loc *time.Location) *Predictions {
+	sessions := PairSessions(events, now)
+	predictions := &Predictions{}
+
+	feedStarts := groupFeeds(sessions, now)
+	if len(feedStarts) > 1 {
+		intervals := make([][2]int64, 0, len(feedStarts)-1)
+		for i := 1; i < len(feedStarts); i++ {
+			intervals = append(intervals, [2]int64{feedStarts[i-1], feedStarts[i] - feedStarts[i-1]})
+		}
+		predictions.NextFeed = predictFrom(feedStarts[len(feedStarts)-1], intervals, loc)
+	}
+
+	// Wake windows, from the end of a sleep to the start of the next one
+	sleeps := make([]Session, 0)
+	for _, session := range sessions {
+		if session.Type == "sleep" {
+			sleeps = append(sleeps, session)
+		}
+	}
+	if len(sleeps) > 1 && !sleeps[len(sleeps)-1].Open {
+		windows := make([][2]int64, 0, len(sleeps)-1)
+		for i := 1; i < len(sleeps); i++ {
+			windows = append(windows, [2]int64{sleeps[i-1].End, sleeps[i].Start - sleeps[i-1].End})
+		}
+		predictions.NextNap = predictFrom(sleeps[len(sleeps)-1].End, windows, loc)
+	}
+
+	return predictions
+}
+
+// predictFrom predicts the end of an interval starting at from, given past
+// intervals as (start, duration) pairs
+func predictFrom(from int64, intervals [][2]int64, loc *time.Location) *Prediction {
+	durations := make([]int64, 0, len(intervals))
+	for _, interval := range intervals {
+		if timeOfDayDistance(interval[0], from, loc) <= timeOfDayWindow {
+			durations = append(durations, interval[1])
+		}
+	}
+	if len(durations) < predictionMinSamples {
+		durations = durations[:0]
+		for _, interval := range intervals {
+			durations = append(durations, interval[1])
+		}
+	}
+	if len(durations) < predictionMinSamples {
+		return nil
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return &Prediction{
+		Expected: from + percentile(durations, 50),
+		Earliest: from + percentile(durations, 25),
+		Latest:   from + percentile(durations, 75),
+		Samples:  len(durations),
+	}
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating
+// between the closest ones
+func percentile(sorted []int64, p int) int64 {
+	rank := float64(p) / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + int64((rank-float64(lower))*float64(sorted[lower+1]-sorted[lower]))
+}
+
+// timeOfDayDistance returns how far apart the local times of day of a and b
+// are, across midnight
+func timeOfDayDistance(a, b int64, loc *time.Location) time.Duration {
+	timeOfDay := func(ts int64) time.Duration {
+		t := time.UnixMilli(ts).In(loc)
+		return t.Sub(StartOfDay(t, loc))
+	}
+	distance := timeOfDay(a) - timeOfDay(b)
+	if distance < 0 {
+		distance = -distance
+	}
+	if distance > 12*time.Hour {
+		distance = 24*time.Hour - distance
+	}
+	return distance
+}
+
+// ReminderLead is how long before a predicted feed or nap the reminder is
+// sent
+const ReminderLead = 15 * time.Minute
+
+// MarkReminderSent records that the reminder of a prediction was sent. It
+// returns false when it already was, so each prediction is reminded once.
+func (us *UserStorage) MarkReminderSent(userID, kind string, expected int64) (bool, error) {
+	key := fmt.Sprintf("user:%s:reminder:%s:%d", userID, kind, expected)
+	return us.redis.SetNX(us.ctx, key, "1", 24*time.Hour).Result()
+}
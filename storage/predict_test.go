@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPredictNext(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	hour := int64(60 * 60 * 1000)
+	minute := int64(60 * 1000)
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, paris).UnixMilli()
+
+	// Feeds every 3 hours, each followed by a 1 hour nap
+	events := make([]DBBabyEvent, 0)
+	for feed := base; feed < base+24*hour; feed += 3 * hour {
+		events = append(events,
+			DBBabyEvent{Timestamp: feed, Name: "leftBoob"},
+			DBBabyEvent{Timestamp: feed + 15*minute, Name: "leftBoobStop"},
+			DBBabyEvent{Timestamp: feed + 30*minute, Name: "sleep"},
+			DBBabyEvent{Timestamp: feed + 90*minute, Name: "wake"},
+		)
+	}
+	lastFeed := base + 21*hour
+	now := lastFeed + 2*hour
+
+	predictions := PredictNext(events, now, paris)
+
+	if predictions.NextFeed == nil || predictions.NextFeed.Expected != lastFeed+3*hour {
+		t.Fatalf("Expected next feed 3 hours after the last one, got %+v", predictions.NextFeed)
+	}
+	if predictions.NextFeed.Earliest > predictions.NextFeed.Expected || predictions.NextFeed.Latest < predictions.NextFeed.Expected {
+		t.Errorf("Expected the range to contain the expected time, got %+v", predictions.NextFeed)
+	}
+	if predictions.NextNap == nil || predictions.NextNap.Expected != lastFeed+90*minute+2*hour {
+		t.Errorf("Expected next nap after a 2 hour wake window, got %+v", predictions.NextNap)
+	}
+
+	t.Run("Not enough history", func(t *testing.T) {
+		predictions := PredictNext(events[:8], base+6*hour, paris)
+		if predictions.NextFeed != nil || predictions.NextNap != nil {
+			t.Errorf("Expected no prediction from a single interval, got %+v", predictions)
+		}
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	values := []int64{10, 20, 30, 40}
+	if got := percentile(values, 50); got != 25 {
+		t.Errorf("Expected median 25, got %d", got)
+	}
+	if got := percentile(values, 100); got != 40 {
+		t.Errorf("Expected max 40, got %d", got)
+	}
+}
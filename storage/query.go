@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultQueryLimit is the page size when the query doesn't set one
+	DefaultQueryLimit = 100
+	// MaxQueryLimit bounds the page size
+	MaxQueryLimit = 1000
+	// queryBatchSize is the number of events read from Redis at once while
+	// filtering a page
+	queryBatchSize = 500
+)
+
+// EventQuery selects a page of events. Filters are combined with AND, the
+// values of a filter with OR.
+type EventQuery struct {
+	Start      int64    // Inclusive, 0 for the beginning
+	End        int64    // Inclusive, 0 for no upper bound
+	Types      []string // Event names
+	Authors    []string
+	Text       string // Case-insensitive substring of the note
+	Limit      int
+	Cursor     string // NextCursor of the previous page
+	Descending bool   // Newest first
+}
+
+// EventPage is a page of events. NextCursor is empty on the last page.
+type EventPage struct {
+	Events     []DBBabyEvent `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// queryCursor is the position after the last event of a page. Events with
+// the same timestamp are ordered by member, which starts with the ID.
+type queryCursor struct {
+	Timestamp int64
+	ID        string
+}
+
+func (c queryCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.Timestamp, c.ID)))
+}
+
+func decodeQueryCursor(cursor string) (*queryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &queryCursor{Timestamp: timestamp, ID: parts[1]}, nil
+}
+
+// matches reports whether the event passes the filters of the query
+func (q *EventQuery) matches(event *DBBabyEvent) bool {
+	if len(q.Types) > 0 && !containsString(q.Types, event.Name) {
+		return false
+	}
+	if len(q.Authors) > 0 && !containsString(q.Authors, event.Author) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(event.Note), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns a page of the events matching the query. The sorted set is
+// read in batches from the cursor until the page is full, so memory stays
+// bounded by the page and batch sizes whatever the history length.
+func (s *Storage) Query(query EventQuery) (*EventPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	min, max := fmt.Sprintf("%d", query.Start), "+inf"
+	if query.End != 0 {
+		max = fmt.Sprintf("%d", query.End)
+	}
+	var cursor *queryCursor
+	if query.Cursor != "" {
+		var err error
+		cursor, err = decodeQueryCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		// Resume at the timestamp of the last event, skipping the events
+		// up to it below
+		if query.Descending {
+			max = fmt.Sprintf("%d", cursor.Timestamp)
+		} else {
+			min = fmt.Sprintf("%d", cursor.Timestamp)
+		}
+	}
+
+	page := &EventPage{Events: make([]DBBabyEvent, 0, limit)}
+	for offset := int64(0); ; offset += queryBatchSize {
+		by := &redis.ZRangeBy{Min: min, Max: max, Offset: offset, Count: queryBatchSize}
+		var members []string
+		var err error
+		if query.Descending {
+			members, err = s.redis.ZRevRangeByScore(s.ctx, s.eventsKey(), by).Result()
+		} else {
+			members, err = s.redis.ZRangeByScore(s.ctx, s.eventsKey(), by).Result()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range members {
+			var event DBBabyEvent
+			if err := json.Unmarshal([]byte(member), &event); err != nil {
+				continue
+			}
+			if cursor != nil && event.Timestamp == cursor.Timestamp {
+				if (!query.Descending && event.ID <= cursor.ID) || (query.Descending && event.ID >= cursor.ID) {
+					continue
+				}
+			}
+			if !query.matches(&event) {
+				continue
+			}
+			if len(page.Events) == limit {
+				last := page.Events[limit-1]
+				page.NextCursor = queryCursor{Timestamp: last.Timestamp, ID: last.ID}.encode()
+				return page, nil
+			}
+			page.Events = append(page.Events, event)
+		}
+		if len(members) < queryBatchSize {
+			return page, nil
+		}
+	}
+}
+
+// Count returns the number of events of the user
+func (s *Storage) Count() (int64, error) {
+	return s.redis.ZCard(s.ctx, s.eventsKey()).Result()
+}
@@ -0,0 +1,43 @@
+package storage
+
+import "testing"
+
+func TestQueryCursor(t *testing.T) {
+	cursor := queryCursor{Timestamp: 1700000000000, ID: "6f1c2d3e-aaaa-bbbb-cccc-000000000001"}
+
+	decoded, err := decodeQueryCursor(cursor.encode())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *decoded != cursor {
+		t.Errorf("Expected %+v, got %+v", cursor, *decoded)
+	}
+
+	if _, err := decodeQueryCursor("not a cursor"); err == nil {
+		t.Error("Expected an error for an invalid cursor")
+	}
+}
+
+func TestEventQueryMatches(t *testing.T) {
+	event := &DBBabyEvent{Name: "poop", Author: "Mathilde", Note: "Très liquide, à surveiller"}
+
+	tests := []struct {
+		name  string
+		query EventQuery
+		want  bool
+	}{
+		{"No filter", EventQuery{}, true},
+		{"Matching type", EventQuery{Types: []string{"pee", "poop"}}, true},
+		{"Other type", EventQuery{Types: []string{"sleep"}}, false},
+		{"Other author", EventQuery{Authors: []string{"Felix"}}, false},
+		{"Note text, any case", EventQuery{Text: "LIQUIDE"}, true},
+		{"Missing note text", EventQuery{Text: "sang"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.query.matches(event); got != test.want {
+				t.Errorf("Expected %t, got %t", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxSeriesBuckets bounds the size of a time-series response
+const maxSeriesBuckets = 1000
+
+// BucketBounds splits [start, end) into hour, day or week buckets in loc.
+// The first bucket starts at start truncated to the hour, or to the start of
+// its day (at dayStartHour) for day and week buckets, so weeks begin on the
+// weekday of start. Day and week buckets follow calendar days, so they are
+// 23 or 25 hours long across DST transitions.
+func BucketBounds(start, end int64, bucket string, dayStartHour int, loc *time.Location) ([][2]int64, error) {
+	if end <= start {
+		return nil, fmt.Errorf("end must be after start")
+	}
+
+	first := time.UnixMilli(start).In(loc)
+	var next func(t time.Time) time.Time
+	switch bucket {
+	case "hour":
+		// Truncate in local time, Truncate works on absolute time and is
+		// off in zones with a half-hour offset
+		first = AtHour(first, first.Hour())
+		next = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case "day", "week":
+		if first.Hour() < dayStartHour {
+			first = first.AddDate(0, 0, -1)
+		}
+		first = AtHour(first, dayStartHour)
+		days := 1
+		if bucket == "week" {
+			days = 7
+		}
+		next = func(t time.Time) time.Time {
+			return AtHour(t.AddDate(0, 0, days), dayStartHour)
+		}
+	default:
+		return nil, fmt.Errorf("bucket must be hour, day or week")
+	}
+
+	bounds := make([][2]int64, 0)
+	for t := first; t.UnixMilli() < end; t = next(t) {
+		if len(bounds) == maxSeriesBuckets {
+			return nil, fmt.Errorf("too many buckets, maximum is %d", maxSeriesBuckets)
+		}
+		bucketEnd := next(t).UnixMilli()
+		if bucketEnd > end {
+			bucketEnd = end
+		}
+		bounds = append(bounds, [2]int64{t.UnixMilli(), bucketEnd})
+	}
+	return bounds, nil
+}
+
+// CalculateSeries computes the stats of every bucket with a single read of
+// the events. Sessions are paired over the whole range then split at bucket
+// boundaries: each bucket gets the part of the duration within it, and the
+// session is counted once, in the bucket where it started.
+func (s *Storage) CalculateSeries(bounds [][2]int64) []*BabyStats {
+	series := make([]*BabyStats, 0, len(bounds))
+	if len(bounds) == 0 {
+		return series
+	}
+	start, end := bounds[0][0], bounds[len(bounds)-1][1]
+
+	events := s.Search(start, end-1)
+	sessions, _ := pairSessions(s.sessionStateAt(start), events, end)
+	i := 0
+	for _, bucket := range bounds {
+		first := i
+		for i < len(events) && events[i].Timestamp < bucket[1] {
+			i++
+		}
+		series = append(series, statsFromSessions(sessions, events[first:i], bucket[0], bucket[1]))
+	}
+	return series
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketBounds(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	start := time.Date(2024, 3, 30, 10, 0, 0, 0, paris).UnixMilli()
+	end := time.Date(2024, 4, 1, 12, 0, 0, 0, paris).UnixMilli()
+
+	bounds, err := BucketBounds(start, end, "day", 7, paris)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(bounds) != 3 {
+		t.Fatalf("Expected 3 day buckets, got %d", len(bounds))
+	}
+	for i, bucket := range bounds {
+		if local := time.UnixMilli(bucket[0]).In(paris); local.Hour() != 7 {
+			t.Errorf("Bucket %d should start at 7am, got %v", i, local)
+		}
+	}
+	// The baby day from March 30th to 31st crosses the switch to summer time
+	if got := time.Duration(bounds[0][1]-bounds[0][0]) * time.Millisecond; got != 23*time.Hour {
+		t.Errorf("Expected 23 hour bucket across DST, got %v", got)
+	}
+	if bounds[2][1] != end {
+		t.Errorf("Expected the last bucket to be clipped at the end of the range")
+	}
+
+	if _, err := BucketBounds(start, end, "minute", 0, paris); err == nil {
+		t.Error("Expected an error for an unknown bucket")
+	}
+}
+
+func TestBucketBoundsHalfHourZone(t *testing.T) {
+	for _, name := range []string{"Asia/Kolkata", "Australia/Adelaide", "Asia/Kathmandu"} {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		start := time.Date(2024, 3, 1, 10, 20, 0, 0, loc).UnixMilli()
+		end := time.Date(2024, 3, 1, 13, 0, 0, 0, loc).UnixMilli()
+
+		bounds, err := BucketBounds(start, end, "hour", 0, loc)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(bounds) != 3 {
+			t.Fatalf("%s: expected 3 hour buckets, got %d", name, len(bounds))
+		}
+		for i, bucket := range bounds {
+			if local := time.UnixMilli(bucket[0]).In(loc); local.Hour() != 10+i || local.Minute() != 0 {
+				t.Errorf("%s: bucket %d should start at %d:00, got %v", name, i, 10+i, local)
+			}
+		}
+	}
+}
+
+func TestSeriesSplitsSessions(t *testing.T) {
+	baseTime := int64(1000000000000)
+	hour := int64(60 * 60 * 1000)
+	events := []DBBabyEvent{
+		{ID: "1", Timestamp: baseTime + 23*hour, Name: "sleep"},
+		{ID: "2", Timestamp: baseTime + 26*hour, Name: "wake"},
+	}
+	sessions, _ := pairSessions(sessionState{}, events, baseTime+48*hour)
+
+	first := statsFromSessions(sessions, events[:1], baseTime, baseTime+24*hour)
+	second := statsFromSessions(sessions, events[1:], baseTime+24*hour, baseTime+48*hour)
+
+	if first.SleepTime != hour || second.SleepTime != 2*hour {
+		t.Errorf("Expected sleep split 1h/2h, got %d/%d", first.SleepTime, second.SleepTime)
+	}
+	// Counted once, in the bucket where it started, with its full duration
+	if first.SleepCount != 1 || first.AverageSleepTime != 3*hour || second.SleepCount != 0 {
+		t.Errorf("Expected the sleep counted once in the first bucket, got %d/%d", first.SleepCount, second.SleepCount)
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+
+	t.Run("Matches the reference scenario", func(t *testing.T) {
+		events := []DBBabyEvent{
+			{ID: "1", Timestamp: baseTime, Name: "sleep"},
+			{ID: "2", Timestamp: baseTime + 30*minute, Name: "wake"},
+			{ID: "3", Timestamp: baseTime + 60*minute, Name: "leftBoob"},
+			{ID: "4", Timestamp: baseTime + 75*minute, Name: "leftBoobStop"},
+			{ID: "5", Timestamp: baseTime + 90*minute, Name: "pee"},
+			{ID: "6", Timestamp: baseTime + 120*minute, Name: "rightBoob"},
+			{ID: "7", Timestamp: baseTime + 140*minute, Name: "rightBoobStop"},
+			{ID: "8", Timestamp: baseTime + 150*minute, Name: "sleep"},
+			{ID: "9", Timestamp: baseTime + 180*minute, Name: "poop"},
+		}
+		stats := computeStats(events, baseTime, baseTime+200*minute, sessionState{})
+		expected := mockStorage{events: events}
+		want := *expected.CalculateStats(baseTime, baseTime+200*minute)
+		// The reference implementation has no feeding analytics: two feeds
+		// an hour apart, the left one 15 min and the right one 20 min
+		want.FeedCount, want.MinFeedInterval, want.AverageFeedInterval, want.MaxFeedInterval = 2, 60*minute, 60*minute, 60*minute
+		want.LeftBoobCountShare, want.LeftBoobDurationShare, want.NextSide = 0.5, 15.0/35, "leftBoob"
+		if *stats != want {
+			t.Errorf("Expected %+v, got %+v", want, *stats)
+		}
+	})
+
+	t.Run("Sessions ongoing at period start", func(t *testing.T) {
+		periodStart := baseTime + 60*minute
+		events := []DBBabyEvent{
+			{ID: "1", Timestamp: periodStart + 30*minute, Name: "leftBoob"}, // Ends the sleep
+			{ID: "2", Timestamp: periodStart + 40*minute, Name: "leftBoobStop"},
+			{ID: "3", Timestamp: periodStart + 50*minute, Name: "rightBoobStop"},
+		}
+		initial := sessionState{SleepStart: baseTime, RightStart: baseTime + 50*minute}
+		stats := computeStats(events, periodStart, periodStart+60*minute, initial)
+
+		if stats.SleepTime != 30*minute || stats.SleepCount != 0 {
+			t.Errorf("Expected 30 min of sleep not counted as a session, got %d ms in %d sessions", stats.SleepTime, stats.SleepCount)
+		}
+		if stats.RightBoobDuration != 50*minute || stats.RightBoobCount != 0 {
+			t.Errorf("Expected 50 min of right feed not counted as a feed, got %d ms in %d feeds", stats.RightBoobDuration, stats.RightBoobCount)
+		}
+		if stats.LeftBoobDuration != 10*minute || stats.LeftBoobCount != 1 {
+			t.Errorf("Expected one 10 min left feed, got %d ms in %d feeds", stats.LeftBoobDuration, stats.LeftBoobCount)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrSessionOverlap is returned when a new session would change the existing
+// sessions or be cut by them
+var ErrSessionOverlap = errors.New("session overlaps existing events")
+
+// Session is a sleep or breastfeeding interval rebuilt from its start and
+// stop events
+type Session struct {
+	Type     string `json:"type"`     // "sleep", "leftBoob" or "rightBoob"
+	Start    int64  `json:"start"`    // Start timestamp in milliseconds
+	End      int64  `json:"end"`      // End timestamp in milliseconds, 0 while open
+	Duration int64  `json:"duration"` // Duration in milliseconds, up to the end of the range while open, at most statsLookback
+	Open     bool   `json:"open"`     // True when no stop event was found
+}
+
+// openUntil returns when the session stops counting: its end, or the end of
+// its duration while open
+func (session Session) openUntil() int64 {
+	if session.Open {
+		return session.Start + session.Duration
+	}
+	return session.End
+}
+
+// sessionState holds the start timestamps of the ongoing sessions, 0 when
+// the session isn't ongoing
+type sessionState struct {
+	SleepStart int64
+	LeftStart  int64
+	RightStart int64
+}
+
+// PairSessions pairs start and stop events into sessions with the same rules
+// as CalculateStats: a sleep ends on wake, on a feed start or on a diaper
+// change, a feed ends on its own stop event, and repeated starts are
+// ignored. Sessions still open are closed at until for their duration.
+func PairSessions(events []DBBabyEvent, until int64) []Session {
+	sessions, _ := pairSessions(sessionState{}, events, until)
+	return sessions
+}
+
+// GetSessions returns the sessions overlapping [start, end], including the
+// ones that started before start. Sessions still open are closed at end for
+// their duration.
+func (s *Storage) GetSessions(start, end int64) []Session {
+	sessions, _ := pairSessions(s.sessionStateAt(start), s.Search(start, end), end)
+	return sessions
+}
+
+// pairSessions is PairSessions starting from the sessions already ongoing in
+// initial. It also returns the sessions still ongoing after the events.
+// A session without stop is left open statsLookback after its start, its
+// duration stopping there, so the sessions ongoing at a point are the same
+// as when replaying only the statsLookback before it.
+func pairSessions(initial sessionState, events []DBBabyEvent, until int64) ([]Session, sessionState) {
+	sessions := make([]Session, 0)
+	var sleep, left, right *Session
+	if initial.SleepStart != 0 {
+		sleep = &Session{Type: "sleep", Start: initial.SleepStart}
+	}
+	if initial.LeftStart != 0 {
+		left = &Session{Type: "leftBoob", Start: initial.LeftStart}
+	}
+	if initial.RightStart != 0 {
+		right = &Session{Type: "rightBoob", Start: initial.RightStart}
+	}
+
+	closeSession := func(open **Session, end int64) {
+		if *open == nil {
+			return
+		}
+		(*open).End = end
+		(*open).Duration = end - (*open).Start
+		sessions = append(sessions, **open)
+		*open = nil
+	}
+
+	expire := func(at int64) {
+		for _, open := range []**Session{&sleep, &left, &right} {
+			if *open != nil && at-(*open).Start > statsLookback {
+				(*open).Open = true
+				(*open).Duration = statsLookback
+				sessions = append(sessions, **open)
+				*open = nil
+			}
+		}
+	}
+
+	for _, event := range events {
+		expire(event.Timestamp)
+		switch event.Name {
+		case "sleep":
+			if sleep == nil {
+				sleep = &Session{Type: "sleep", Start: event.Timestamp}
+			}
+		case "wake", "pee", "poop":
+			closeSession(&sleep, event.Timestamp)
+		case "leftBoob":
+			if left == nil {
+				left = &Session{Type: "leftBoob", Start: event.Timestamp}
+			}
+			closeSession(&sleep, event.Timestamp)
+		case "leftBoobStop":
+			closeSession(&left, event.Timestamp)
+		case "rightBoob":
+			if right == nil {
+				right = &Session{Type: "rightBoob", Start: event.Timestamp}
+			}
+			closeSession(&sleep, event.Timestamp)
+		case "rightBoobStop":
+			closeSession(&right, event.Timestamp)
+		}
+	}
+
+	expire(until)
+	state := sessionState{}
+	for _, open := range []*Session{sleep, left, right} {
+		if open != nil {
+			open.Open = true
+			open.Duration = until - open.Start
+			sessions = append(sessions, *open)
+		}
+	}
+	if sleep != nil {
+		state.SleepStart = sleep.Start
+	}
+	if left != nil {
+		state.LeftStart = left.Start
+	}
+	if right != nil {
+		state.RightStart = right.Start
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start < sessions[j].Start
+	})
+	return sessions, state
+}
+
+// isSessionEvent reports whether the event opens or closes a session and is
+// therefore represented by PairSessions
+func isSessionEvent(name string) bool {
+	switch name {
+	case "sleep", "wake", "leftBoob", "leftBoobStop", "rightBoob", "rightBoobStop":
+		return true
+	}
+	return false
+}
+
+// sessionFits reports whether the session of kind from start to end can be
+// inserted among the events without changing any other session nor being
+// cut by them. initial holds the sessions going on at start and events are
+// the events of [start, end].
+func sessionFits(kind string, start, end int64, initial sessionState, events []DBBabyEvent) bool {
+	before, _ := pairSessions(initial, events, end)
+
+	inserted := make([]DBBabyEvent, 0, len(events)+2)
+	inserted = append(inserted, DBBabyEvent{Timestamp: start, Name: kind})
+	inserted = append(inserted, events...)
+	inserted = append(inserted, DBBabyEvent{Timestamp: end, Name: sessionStops[kind]})
+	sort.SliceStable(inserted, func(i, j int) bool {
+		return inserted[i].Timestamp < inserted[j].Timestamp
+	})
+	after, _ := pairSessions(initial, inserted, end)
+
+	expected := append(before, Session{Type: kind, Start: start, End: end, Duration: end - start})
+	sort.SliceStable(expected, func(i, j int) bool {
+		return expected[i].Start < expected[j].Start
+	})
+	if len(after) != len(expected) {
+		return false
+	}
+	for i := range after {
+		if after[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateSession stores a completed session as its start and stop events,
+// written together. It fails with ErrSessionOverlap, along with the
+// sessions overlapping the new one, when the session doesn't fit among the
+// existing events.
+func (s *Storage) CreateSession(kind string, start, end int64, note string) ([]DBBabyEvent, []Session, error) {
+	stop, ok := sessionStops[kind]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown session type %q", kind)
+	}
+	if end <= start {
+		return nil, nil, fmt.Errorf("session must end after its start")
+	}
+	events := []DBBabyEvent{
+		{ID: uuid.New().String(), Timestamp: start, Name: kind, Author: s.eventAuthor(), Revision: 1, Note: note, Source: SourceRecorded},
+		{ID: uuid.New().String(), Timestamp: end, Name: stop, Author: s.eventAuthor(), Revision: 1, Source: SourceRecorded},
+	}
+	members := make([]redis.Z, 0, len(events))
+	for i := range events {
+		member, err := events[i].Json()
+		if err != nil {
+			return nil, nil, err
+		}
+		members = append(members, redis.Z{Score: float64(events[i].Timestamp), Member: member})
+	}
+
+	var overlapping []Session
+	txf := func(tx *redis.Tx) error {
+		initial := s.sessionStateAt(start)
+		existing := s.Search(start, end)
+		if !sessionFits(kind, start, end, initial, existing) {
+			sessions, _ := pairSessions(initial, existing, end)
+			overlapping = make([]Session, 0)
+			for _, session := range sessions {
+				if session.Start < end && (session.Open || session.End > start) {
+					overlapping = append(overlapping, session)
+				}
+			}
+			return ErrSessionOverlap
+		}
+		_, err := tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(s.ctx, s.eventsKey(), members...)
+			return nil
+		})
+		return err
+	}
+
+	// Retry when another write happened between the check and the insert
+	for i := 0; i < 3; i++ {
+		err := s.redis.Watch(s.ctx, txf, s.eventsKey())
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, overlapping, err
+		}
+		fmt.Printf("Session %s created from %d to %d\n", kind, start, end)
+		s.invalidateAggregates(start, end)
+		for i := range events {
+			s.publish("created", &events[i], events[i].Timestamp)
+		}
+		return events, nil, nil
+	}
+	return nil, nil, redis.TxFailedErr
+}
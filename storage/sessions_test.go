@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPairSessions(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+	events := []DBBabyEvent{
+		{ID: "1", Timestamp: baseTime, Name: "sleep"},
+		{ID: "2", Timestamp: baseTime + 10*minute, Name: "sleep"},    // Ignored, already sleeping
+		{ID: "3", Timestamp: baseTime + 40*minute, Name: "leftBoob"}, // Ends the sleep
+		{ID: "4", Timestamp: baseTime + 55*minute, Name: "leftBoobStop"},
+		{ID: "5", Timestamp: baseTime + 60*minute, Name: "rightBoobStop"}, // Orphan stop, ignored
+		{ID: "6", Timestamp: baseTime + 70*minute, Name: "sleep"},
+		{ID: "7", Timestamp: baseTime + 100*minute, Name: "pee"}, // Ends the sleep
+		{ID: "8", Timestamp: baseTime + 110*minute, Name: "rightBoob"},
+	}
+
+	sessions := PairSessions(events, baseTime+120*minute)
+
+	expected := []Session{
+		{Type: "sleep", Start: baseTime, End: baseTime + 40*minute, Duration: 40 * minute},
+		{Type: "leftBoob", Start: baseTime + 40*minute, End: baseTime + 55*minute, Duration: 15 * minute},
+		{Type: "sleep", Start: baseTime + 70*minute, End: baseTime + 100*minute, Duration: 30 * minute},
+		{Type: "rightBoob", Start: baseTime + 110*minute, Duration: 10 * minute, Open: true},
+	}
+	if len(sessions) != len(expected) {
+		t.Fatalf("Expected %d sessions, got %d: %+v", len(expected), len(sessions), sessions)
+	}
+	for i := range expected {
+		if sessions[i] != expected[i] {
+			t.Errorf("Session %d: expected %+v, got %+v", i, expected[i], sessions[i])
+		}
+	}
+}
+
+func TestBuildExportRows(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+	events := []DBBabyEvent{
+		{ID: "1", Timestamp: baseTime, Name: "sleep", Note: "dans le berceau"},
+		{ID: "2", Timestamp: baseTime + 30*minute, Name: "wake"},
+		{ID: "3", Timestamp: baseTime + 35*minute, Name: "poop", Note: "liquide"},
+	}
+
+	rows := BuildExportRows(events, baseTime+60*minute)
+
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d: %+v", len(rows), rows)
+	}
+	if !rows[0].Session || rows[0].Name != "sleep" || rows[0].Duration != 30*minute {
+		t.Errorf("Expected a 30 minute sleep session first, got %+v", rows[0])
+	}
+	if rows[0].Label != "💤 Sommeil" || rows[0].Note != "dans le berceau" {
+		t.Errorf("Expected the sleep session label and the note of its start, got %+v", rows[0])
+	}
+	if rows[1].Session || rows[1].Name != "poop" || rows[1].Label != EventDisplayName("poop") || rows[1].Note != "liquide" {
+		t.Errorf("Expected a poop event with its note second, got %+v", rows[1])
+	}
+}
+
+func TestWriteExportCSV(t *testing.T) {
+	rows := []ExportRow{
+		{Name: "leftBoob", Label: SessionDisplayName("leftBoob"), Start: 1000000000000, End: 1000000600000, Duration: 600000, Session: true},
+		{Name: "pee", Label: EventDisplayName("pee"), Start: 1000000900000, Note: "couche pleine"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteExport(&buf, "csv", rows, time.UTC); err != nil {
+		t.Fatalf("WriteExport failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimPrefix(strings.TrimSpace(buf.String()), "\ufeff"), "\n")
+	expected := []string{
+		"Événement,Début,Fin,Durée (min),En cours,Note",
+		"🍼 Allaitement (sein gauche),2001-09-09 01:46:40,2001-09-09 01:56:40,10,,",
+		"💧 Pipi,2001-09-09 02:01:40,,,,couche pleine",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q", len(expected), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestBuildExportRowsOngoingSessions(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+	// The sleep started before the exported range
+	initial := sessionState{SleepStart: baseTime - 20*minute}
+	events := []DBBabyEvent{
+		{ID: "1", Timestamp: baseTime + 10*minute, Name: "wake"},
+		{ID: "2", Timestamp: baseTime + 15*minute, Name: "pee"},
+	}
+	sessions, _ := pairSessions(initial, events, baseTime+60*minute)
+
+	rows := buildExportRows(sessions, events, baseTime+60*minute)
+
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d: %+v", len(rows), rows)
+	}
+	if rows[0].Name != "sleep" || rows[0].Start != baseTime-20*minute || rows[0].Duration != 30*minute {
+		t.Errorf("Expected the sleep started before the range first, got %+v", rows[0])
+	}
+}
+
+func TestSessionFits(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+	events := []DBBabyEvent{
+		{Timestamp: baseTime + 30*minute, Name: "leftBoob"},
+		{Timestamp: baseTime + 45*minute, Name: "leftBoobStop"},
+		{Timestamp: baseTime + 50*minute, Name: "pee"},
+	}
+
+	tests := []struct {
+		name    string
+		kind    string
+		start   int64
+		end     int64
+		initial sessionState
+		events  []DBBabyEvent
+		fits    bool
+	}{
+		{"Nap before the feed", "sleep", baseTime, baseTime + 20*minute, sessionState{}, nil, true},
+		{"Nap cut by the feed", "sleep", baseTime, baseTime + 40*minute, sessionState{}, events[:1], false},
+		{"Nap ended by the diaper", "sleep", baseTime + 46*minute, baseTime + 55*minute, sessionState{}, events[2:], false},
+		{"Other side during the feed", "rightBoob", baseTime + 35*minute, baseTime + 40*minute, sessionState{LeftStart: baseTime + 30*minute}, nil, true},
+		{"Same side during the feed", "leftBoob", baseTime + 35*minute, baseTime + 40*minute, sessionState{LeftStart: baseTime + 30*minute}, nil, false},
+		{"Feed during a sleep", "leftBoob", baseTime + 10*minute, baseTime + 20*minute, sessionState{SleepStart: baseTime}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fits := sessionFits(tt.kind, tt.start, tt.end, tt.initial, tt.events); fits != tt.fits {
+				t.Errorf("Expected fits %v, got %v", tt.fits, fits)
+			}
+		})
+	}
+}
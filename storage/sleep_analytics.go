@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// NightWindow is the part of the day counted as night, in local hours. The
+// window usually wraps around midnight, e.g. 19h to 7h.
+type NightWindow struct {
+	StartHour int `json:"night_start_hour"`
+	EndHour   int `json:"night_end_hour"`
+}
+
+// DefaultNightWindow is used when the request doesn't specify one
+var DefaultNightWindow = NightWindow{StartHour: 19, EndHour: 7}
+
+// Validate checks the window hours
+func (w NightWindow) Validate() error {
+	if w.StartHour < 0 || w.StartHour > 23 || w.EndHour < 0 || w.EndHour > 23 || w.StartHour == w.EndHour {
+		return fmt.Errorf("night window hours must be distinct and between 0 and 23")
+	}
+	return nil
+}
+
+// SleepAnalytics are the sleep metrics sleep consultants ask for, on top of
+// the totals of BabyStats
+type SleepAnalytics struct {
+	LongestStretch      int64 `json:"longest_stretch"`       // Longest continuous sleep in milliseconds
+	LongestStretchStart int64 `json:"longest_stretch_start"` // Start timestamp of the longest sleep
+	NightSleepTime      int64 `json:"night_sleep_time"`      // Sleep within the night window in milliseconds
+	DaySleepTime        int64 `json:"day_sleep_time"`        // Sleep outside the night window in milliseconds
+	NapCount            int   `json:"nap_count"`             // Sleeps started outside the night window
+	NightWakings        int   `json:"night_wakings"`         // Wakings followed by more sleep in the same night
+	WakeWindowCount     int   `json:"wake_window_count"`     // Number of awake periods between sleeps, night wakings excluded
+	AverageWakeWindow   int64 `json:"average_wake_window"`   // In milliseconds
+	ShortestWakeWindow  int64 `json:"shortest_wake_window"`  // In milliseconds
+	LongestWakeWindow   int64 `json:"longest_wake_window"`   // In milliseconds
+	PeriodStart         int64 `json:"period_start"`
+	PeriodEnd           int64 `json:"period_end"`
+}
+
+// CalculateSleepAnalytics computes the sleep analytics of a period, with the
+// same session pairing as CalculateStats
+func (s *Storage) CalculateSleepAnalytics(start, end int64, window NightWindow) *SleepAnalytics {
+	events := s.Search(start, end)
+	sessions, _ := pairSessions(s.sessionStateAt(start), events, end)
+	return computeSleepAnalytics(sessions, start, end, window, s.loc)
+}
+
+// computeSleepAnalytics derives the sleep analytics from paired sessions.
+// Durations are clipped to the period, except the longest stretch which is
+// the full length of the sleep.
+func computeSleepAnalytics(sessions []Session, start, end int64, window NightWindow, loc *time.Location) *SleepAnalytics {
+	analytics := &SleepAnalytics{
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+
+	sleeps := make([]Session, 0)
+	for _, session := range sessions {
+		if session.Type == "sleep" {
+			sleeps = append(sleeps, session)
+		}
+	}
+
+	var totalWakeWindow int64
+	for i, sleep := range sleeps {
+		sleepEnd := sleep.End
+		if sleep.Open {
+			sleepEnd = end
+		}
+		if sleepEnd-sleep.Start > analytics.LongestStretch {
+			analytics.LongestStretch = sleepEnd - sleep.Start
+			analytics.LongestStretchStart = sleep.Start
+		}
+
+		from, to := sleep.Start, sleepEnd
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		if to > from {
+			night := nightOverlap(from, to, window, loc)
+			analytics.NightSleepTime += night
+			analytics.DaySleepTime += to - from - night
+		}
+		if sleep.Start >= start && !isNight(sleep.Start, window, loc) {
+			analytics.NapCount++
+		}
+
+		if sleep.Open || i+1 == len(sleeps) || sleep.End < start {
+			continue
+		}
+		next := sleeps[i+1]
+		if isNight(sleep.End, window, loc) && isNight(next.Start, window, loc) &&
+			nightStart(sleep.End, window, loc) == nightStart(next.Start, window, loc) {
+			// Back to sleep within the same night
+			analytics.NightWakings++
+			continue
+		}
+		awake := next.Start - sleep.End
+		totalWakeWindow += awake
+		analytics.WakeWindowCount++
+		if analytics.ShortestWakeWindow == 0 || awake < analytics.ShortestWakeWindow {
+			analytics.ShortestWakeWindow = awake
+		}
+		if awake > analytics.LongestWakeWindow {
+			analytics.LongestWakeWindow = awake
+		}
+	}
+	if analytics.WakeWindowCount > 0 {
+		analytics.AverageWakeWindow = totalWakeWindow / int64(analytics.WakeWindowCount)
+	}
+
+	return analytics
+}
+
+// nightStart returns the start of the night containing ts, or of the last
+// night before it
+func nightStart(ts int64, window NightWindow, loc *time.Location) int64 {
+	t := time.UnixMilli(ts).In(loc)
+	start := AtHour(t, window.StartHour)
+	if start.After(t) {
+		start = AtHour(t.AddDate(0, 0, -1), window.StartHour)
+	}
+	return start.UnixMilli()
+}
+
+// nightEnd returns the end of the night starting at nightStartTs
+func nightEnd(nightStartTs int64, window NightWindow, loc *time.Location) int64 {
+	t := time.UnixMilli(nightStartTs).In(loc)
+	if window.EndHour > window.StartHour {
+		return AtHour(t, window.EndHour).UnixMilli()
+	}
+	return AtHour(t.AddDate(0, 0, 1), window.EndHour).UnixMilli()
+}
+
+func isNight(ts int64, window NightWindow, loc *time.Location) bool {
+	return ts < nightEnd(nightStart(ts, window, loc), window, loc)
+}
+
+// nightOverlap returns how much of [from, to) falls within night windows
+func nightOverlap(from, to int64, window NightWindow, loc *time.Location) int64 {
+	var overlap int64
+	for nightFrom := nightStart(from, window, loc); nightFrom < to; {
+		nightTo := nightEnd(nightFrom, window, loc)
+		a, b := nightFrom, nightTo
+		if a < from {
+			a = from
+		}
+		if b > to {
+			b = to
+		}
+		if b > a {
+			overlap += b - a
+		}
+		nightFrom = AtHour(time.UnixMilli(nightFrom).In(loc).AddDate(0, 0, 1), window.StartHour).UnixMilli()
+	}
+	return overlap
+}
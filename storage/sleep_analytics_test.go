@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeSleepAnalytics(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	at := func(day, hour, minute int) int64 {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, paris).UnixMilli()
+	}
+	hour := int64(60 * 60 * 1000)
+	minute := int64(60 * 1000)
+
+	events := []DBBabyEvent{
+		{Timestamp: at(1, 20, 0), Name: "sleep"},
+		{Timestamp: at(2, 1, 0), Name: "wake"}, // Night waking
+		{Timestamp: at(2, 1, 30), Name: "sleep"},
+		{Timestamp: at(2, 6, 30), Name: "wake"},
+		{Timestamp: at(2, 9, 0), Name: "sleep"}, // Nap after a 2h30 wake window
+		{Timestamp: at(2, 10, 0), Name: "pee"},
+		{Timestamp: at(2, 12, 0), Name: "sleep"}, // Nap after a 2h wake window
+		{Timestamp: at(2, 13, 30), Name: "wake"},
+	}
+	start, end := at(1, 19, 0), at(2, 19, 0)
+	sessions := PairSessions(events, end)
+
+	analytics := computeSleepAnalytics(sessions, start, end, DefaultNightWindow, paris)
+
+	if analytics.LongestStretch != 5*hour || analytics.LongestStretchStart != at(1, 20, 0) {
+		t.Errorf("Expected a 5h longest stretch starting at 20:00, got %d from %d", analytics.LongestStretch, analytics.LongestStretchStart)
+	}
+	if analytics.NightSleepTime != 10*hour {
+		t.Errorf("Expected 10h of night sleep, got %v", time.Duration(analytics.NightSleepTime)*time.Millisecond)
+	}
+	if analytics.DaySleepTime != 2*hour+30*minute {
+		t.Errorf("Expected 2h30 of day sleep, got %v", time.Duration(analytics.DaySleepTime)*time.Millisecond)
+	}
+	if analytics.NapCount != 2 {
+		t.Errorf("Expected 2 naps, got %d", analytics.NapCount)
+	}
+	if analytics.NightWakings != 1 {
+		t.Errorf("Expected 1 night waking, got %d", analytics.NightWakings)
+	}
+	if analytics.WakeWindowCount != 2 || analytics.ShortestWakeWindow != 2*hour || analytics.LongestWakeWindow != 2*hour+30*minute {
+		t.Errorf("Expected wake windows of 2h and 2h30, got %d windows from %d to %d", analytics.WakeWindowCount, analytics.ShortestWakeWindow, analytics.LongestWakeWindow)
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// statusPageSize is the number of events read per page when looking back
+// for the current status
+const statusPageSize = 50
+
+// statusCategories are the categories reported in Status.Last
+var statusCategories = map[string]string{
+	"sleep":     "sleep",
+	"wake":      "wake",
+	"leftBoob":  "feed",
+	"rightBoob": "feed",
+	"pee":       "pee",
+	"poop":      "poop",
+}
+
+// LastEvent is the most recent event of a category
+type LastEvent struct {
+	Name      string `json:"name"`
+	Timestamp int64  `json:"timestamp"`
+	Ago       int64  `json:"ago"` // Time since the event in milliseconds
+}
+
+// Status is the current state of the baby
+type Status struct {
+	State       string                `json:"state"`          // "sleeping", "feeding" or "awake"
+	Side        string                `json:"side,omitempty"` // "leftBoob" or "rightBoob" while feeding
+	Since       int64                 `json:"since"`          // When the state began, 0 when unknown
+	Duration    int64                 `json:"duration"`       // Time in the state in milliseconds, 0 when unknown
+	Last        map[string]*LastEvent `json:"last"`           // Last sleep, wake, feed, pee and poop
+	Now         int64                 `json:"now"`
+	Predictions *Predictions          `json:"predictions,omitempty"`
+}
+
+// GetStatus returns the current state of the baby. The events are read
+// backwards by pages, only until the last event of every category and the
+// start of the ongoing sessions are known, within statsLookback.
+func (s *Storage) GetStatus(now int64) (*Status, error) {
+	// Events newest first
+	events := make([]DBBabyEvent, 0)
+	for offset := int64(0); ; offset += statusPageSize {
+		members, err := s.redis.ZRevRangeByScore(s.ctx, s.eventsKey(), &redis.ZRangeBy{
+			Min:    fmt.Sprintf("%d", now-statsLookback),
+			Max:    fmt.Sprintf("%d", now),
+			Offset: offset,
+			Count:  statusPageSize,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			var event DBBabyEvent
+			if err := json.Unmarshal([]byte(member), &event); err != nil {
+				continue
+			}
+			events = append(events, event)
+		}
+		if len(members) < statusPageSize || statusComplete(events) {
+			break
+		}
+	}
+
+	// Back to chronological order for the session pairing
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return computeStatus(events, now), nil
+}
+
+// statusComplete reports whether the events, newest first, are enough to
+// compute the status: every category was found, and a stop event older than
+// the current sleep and feeds tells when they started
+func statusComplete(events []DBBabyEvent) bool {
+	found := make(map[string]bool)
+	var sleepClosed, leftClosed, rightClosed bool
+	for _, event := range events {
+		if category, ok := statusCategories[event.Name]; ok {
+			found[category] = true
+		}
+		switch event.Name {
+		case "wake", "pee", "poop", "leftBoob", "rightBoob":
+			sleepClosed = true
+		case "leftBoobStop":
+			leftClosed = true
+		case "rightBoobStop":
+			rightClosed = true
+		}
+	}
+	return len(found) == 5 && sleepClosed && leftClosed && rightClosed
+}
+
+// computeStatus derives the status from the chronological events preceding
+// now
+func computeStatus(events []DBBabyEvent, now int64) *Status {
+	status := &Status{
+		State: "awake",
+		Last:  make(map[string]*LastEvent),
+		Now:   now,
+	}
+	for _, event := range events {
+		if category, ok := statusCategories[event.Name]; ok {
+			status.Last[category] = &LastEvent{
+				Name:      event.Name,
+				Timestamp: event.Timestamp,
+				Ago:       now - event.Timestamp,
+			}
+		}
+	}
+
+	sessions, _ := pairSessions(sessionState{}, events, now)
+	var lastSleepEnd int64
+	for _, session := range sessions {
+		switch {
+		case session.Type == "sleep" && session.Open:
+			status.State = "sleeping"
+			status.Since = session.Start
+		case session.Type == "sleep":
+			if session.End > lastSleepEnd {
+				lastSleepEnd = session.End
+			}
+		case session.Open && status.State != "sleeping":
+			// With both sides open, the feed began with the first one and
+			// continues on the last one
+			if status.State != "feeding" {
+				status.Since = session.Start
+			}
+			status.State = "feeding"
+			status.Side = session.Type
+		}
+	}
+	if status.State == "awake" {
+		status.Since = lastSleepEnd
+	}
+	if status.Since != 0 {
+		status.Duration = now - status.Since
+	}
+	return status
+}
+
+// BabyState is the state of the baby at a point in time. Now is that point.
+type BabyState struct {
+	Status
+	Ongoing    []Session  `json:"ongoing"`               // Sessions going on, with their duration so far
+	LastDiaper *LastEvent `json:"last_diaper,omitempty"` // Last pee or poop
+}
+
+// StateAt rebuilds the state of the baby at the given timestamp from the
+// events of the statsLookback before it, replayed like in CalculateStats
+func (s *Storage) StateAt(at int64) *BabyState {
+	return stateFromEvents(s.Search(at-statsLookback, at), at)
+}
+
+// stateFromEvents derives the state at at from the chronological events up
+// to it
+func stateFromEvents(events []DBBabyEvent, at int64) *BabyState {
+	state := &BabyState{
+		Status:  *computeStatus(events, at),
+		Ongoing: make([]Session, 0),
+	}
+	sessions, _ := pairSessions(sessionState{}, events, at)
+	for _, session := range sessions {
+		if session.Open {
+			state.Ongoing = append(state.Ongoing, session)
+		}
+	}
+	for _, category := range []string{"pee", "poop"} {
+		last := state.Last[category]
+		if last != nil && (state.LastDiaper == nil || last.Timestamp > state.LastDiaper.Timestamp) {
+			state.LastDiaper = last
+		}
+	}
+	return state
+}
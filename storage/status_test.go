@@ -0,0 +1,82 @@
+package storage
+
+import "testing"
+
+func TestComputeStatus(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+
+	t.Run("Sleeping", func(t *testing.T) {
+		events := []DBBabyEvent{
+			{Timestamp: baseTime, Name: "leftBoob"},
+			{Timestamp: baseTime + 10*minute, Name: "leftBoobStop"},
+			{Timestamp: baseTime + 20*minute, Name: "pee"},
+			{Timestamp: baseTime + 30*minute, Name: "sleep"},
+			{Timestamp: baseTime + 40*minute, Name: "sleep"}, // Ignored, already sleeping
+		}
+		status := computeStatus(events, baseTime+72*minute)
+
+		if status.State != "sleeping" || status.Since != baseTime+30*minute || status.Duration != 42*minute {
+			t.Errorf("Expected sleeping for 42 minutes, got %+v", status)
+		}
+		if feed := status.Last["feed"]; feed == nil || feed.Name != "leftBoob" || feed.Ago != 72*minute {
+			t.Errorf("Expected last feed on the left 72 minutes ago, got %+v", feed)
+		}
+		if status.Last["poop"] != nil {
+			t.Errorf("Expected no last poop, got %+v", status.Last["poop"])
+		}
+	})
+
+	t.Run("Feeding after a sleep", func(t *testing.T) {
+		events := []DBBabyEvent{
+			{Timestamp: baseTime, Name: "sleep"},
+			{Timestamp: baseTime + 60*minute, Name: "rightBoob"},
+		}
+		status := computeStatus(events, baseTime+70*minute)
+
+		if status.State != "feeding" || status.Side != "rightBoob" || status.Since != baseTime+60*minute {
+			t.Errorf("Expected feeding on the right since the feed start, got %+v", status)
+		}
+	})
+
+	t.Run("Awake since the last wake", func(t *testing.T) {
+		events := []DBBabyEvent{
+			{Timestamp: baseTime, Name: "sleep"},
+			{Timestamp: baseTime + 60*minute, Name: "wake"},
+			{Timestamp: baseTime + 65*minute, Name: "poop"},
+		}
+		status := computeStatus(events, baseTime+90*minute)
+
+		if status.State != "awake" || status.Since != baseTime+60*minute || status.Duration != 30*minute {
+			t.Errorf("Expected awake for 30 minutes, got %+v", status)
+		}
+	})
+}
+
+func TestStateFromEvents(t *testing.T) {
+	baseTime := int64(1000000000000)
+	minute := int64(60 * 1000)
+	events := []DBBabyEvent{
+		{Timestamp: baseTime, Name: "poop"},
+		{Timestamp: baseTime + 10*minute, Name: "leftBoob"},
+		{Timestamp: baseTime + 20*minute, Name: "pee"},
+		{Timestamp: baseTime + 30*minute, Name: "rightBoob"},
+		{Timestamp: baseTime + 40*minute, Name: "rightBoobStop"},
+	}
+
+	state := stateFromEvents(events[:4], baseTime+35*minute)
+	if state.State != "feeding" || state.Side != "rightBoob" || state.Since != baseTime+10*minute {
+		t.Errorf("Expected feeding on the right since the left feed start, got %+v", state.Status)
+	}
+	if len(state.Ongoing) != 2 || state.Ongoing[0].Type != "leftBoob" || state.Ongoing[1].Duration != 5*minute {
+		t.Errorf("Expected both sides ongoing, got %+v", state.Ongoing)
+	}
+	if state.LastDiaper == nil || state.LastDiaper.Name != "pee" || state.LastDiaper.Ago != 15*minute {
+		t.Errorf("Expected the pee 15 minutes before as last diaper, got %+v", state.LastDiaper)
+	}
+
+	state = stateFromEvents(events[:1], baseTime+5*minute)
+	if state.State != "awake" || len(state.Ongoing) != 0 || state.LastDiaper.Name != "poop" {
+		t.Errorf("Expected awake after the poop, got %+v", state)
+	}
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -16,6 +17,8 @@ type Storage struct {
 	redis  *redis.Client
 	userID string
 	keys   map[string]string
+	loc    *time.Location
+	author string
 }
 
 type DBBabyEvent struct {
@@ -23,8 +26,25 @@ type DBBabyEvent struct {
 	Timestamp int64  `json:"timestamp"`
 	Name      string `json:"name"`
 	Author    string `json:"author"` // Felix ou Mathilde
+	Revision  int64  `json:"revision"`
+	Note      string `json:"note,omitempty"`
+	Source    string `json:"source,omitempty"` // How the event was created, recorded when empty
 }
 
+// Event sources. Only recorded events were entered by the parents, the
+// others were derived by BabyCheck and may be recalculated.
+const (
+	SourceRecorded   = "recorded"    // Tapped or entered by a parent
+	SourceInferred   = "inferred"    // Implied by the toggle rules, like the wake before a feed
+	SourceImported   = "imported"    // Read from an import file
+	SourceAutoClosed = "auto-closed" // Stop inserted by AutoCloseSessions
+)
+
+var (
+	ErrEventNotFound    = errors.New("event not found")
+	ErrRevisionConflict = errors.New("revision conflict")
+)
+
 func (e *DBBabyEvent) Json() (string, error) {
 	marshalled, err := json.Marshal(e)
 	if err != nil {
@@ -45,6 +65,7 @@ func NewStorage(userID string) *Storage {
 		ctx:    context.Background(),
 		redis:  rdb,
 		userID: userID,
+		loc:    DefaultLocation(),
 	}
 	st.keys = map[string]string{
 		"babyevents": fmt.Sprintf("user:%s:ts_events", userID),
@@ -53,17 +74,64 @@ func NewStorage(userID string) *Storage {
 	return st
 }
 
+// SetLocation sets the time zone used for day, week and month boundaries
+func (s *Storage) SetLocation(loc *time.Location) {
+	s.loc = loc
+}
+
+// Location returns the time zone of the user's calendar days
+func (s *Storage) Location() *time.Location {
+	return s.loc
+}
+
+// SetAuthor sets the caregiver recorded as author of the new events
+func (s *Storage) SetAuthor(author string) {
+	s.author = author
+}
+
+// eventAuthor returns the author of the new events, "None" when the client
+// didn't tell who is tapping
+func (s *Storage) eventAuthor() string {
+	if s.author == "" {
+		return "None"
+	}
+	return s.author
+}
+
+// eventsKey returns the sorted set holding the events, the debug one outside
+// of release mode
+func (s *Storage) eventsKey() string {
+	if os.Getenv("GIN_MODE") != "release" {
+		return s.keys["debug"]
+	}
+	return s.keys["babyevents"]
+}
+
 func (s *Storage) Save(timestamp int64, name string) bool {
-	id := uuid.New().String()
-	dbEvent := &DBBabyEvent{
-		ID:        id,
+	_, err := s.SaveEvent(DBBabyEvent{
 		Timestamp: timestamp,
 		Name:      name,
-	}
+	})
+	return err == nil
+}
+
+// SaveEvent stores a new event with its optional fields, under a new ID. It
+// returns the stored event, recorded unless its source says otherwise.
+func (s *Storage) SaveEvent(event DBBabyEvent) (*DBBabyEvent, error) {
+	dbEvent := &event
+	dbEvent.ID = uuid.New().String()
+	dbEvent.Revision = 1
+	if dbEvent.Source == "" {
+		dbEvent.Source = SourceRecorded
+	}
+	if dbEvent.Author == "" {
+		dbEvent.Author = s.eventAuthor()
+	}
+	timestamp := dbEvent.Timestamp
 	jsonEvent, err := dbEvent.Json()
 	if err != nil {
 		fmt.Printf("Failed to marshal event: %+v\n", err)
-		return false
+		return nil, err
 	}
 	bucket := s.keys["babyevents"]
 	if os.Getenv("GIN_MODE") != "release" {
@@ -75,86 +143,17 @@ func (s *Storage) Save(timestamp int64, name string) bool {
 	}
 	res := s.redis.ZAdd(s.ctx, bucket, zData)
 	fmt.Println("Added: ", res.String())
-	return res.Val() != 0
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+	s.invalidateAggregates(timestamp)
+	s.publish("created", dbEvent, timestamp)
+	return dbEvent, nil
 }
 
 func (s *Storage) Update(action string, ts time.Time) bool {
-	id := uuid.New().String()
-	bucket := s.keys["babyevents"]
-	if os.Getenv("GIN_MODE") != "release" {
-		bucket = s.keys["debug"]
-	}
-	lastEventRes := s.redis.ZRevRangeByScore(s.ctx, bucket, &redis.ZRangeBy{
-		Min:    "-inf",
-		Max:    "+inf",
-		Offset: 0,
-		Count:  1,
-	})
-	lastEvent := DBBabyEvent{}
-	if len(lastEventRes.Val()) > 0 {
-		json.Unmarshal([]byte(lastEventRes.Val()[0]), &lastEvent)
-	}
-
-	// if sleeping and receive sleep event, transform to wake
-	if action == "sleep" && lastEvent.Name == "sleep" {
-		action = "wake"
-	}
-	// if eating (leftBoob or rightBoob) and last event was eating, add stop
-	if (action == "leftBoob") && (lastEvent.Name == "leftBoob") {
-		action += "Stop"
-	}
-	if (action == "rightBoob") && (lastEvent.Name == "rightBoob") {
-		action += "Stop"
-	}
-	addWakeAction := false
-	if (action == "pee" || action == "poo") && (lastEvent.Name == "sleep") {
-		// Add a wake action
-		addWakeAction = true
-	}
-	// if eating (leftBoob or rightBoob) and last event was sleeping, wake up
-	if (action == "leftBoob" || action == "rightBoob") && (lastEvent.Name == "sleep") {
-		addWakeAction = true
-	}
-
-	if addWakeAction {
-		// Add a wake action
-		wakeEvent := &DBBabyEvent{
-			ID: uuid.New().String(),
-			// Add 1ms to avoid conflicts
-			Timestamp: time.Now().UnixMilli() - 1000,
-			Name:      "wake",
-			Author:    "None",
-		}
-		jsonWakeEvent, err := wakeEvent.Json()
-		if err != nil {
-			fmt.Printf("Failed to marshal event: %+v\n", err)
-			return false
-		}
-		zWakeData := redis.Z{
-			Score:  float64(wakeEvent.Timestamp),
-			Member: jsonWakeEvent,
-		}
-		s.redis.ZAdd(s.ctx, bucket, zWakeData)
-	}
-
-	dbEvent := &DBBabyEvent{
-		ID:        id,
-		Timestamp: ts.UnixMilli(),
-		Name:      action,
-		Author:    "None",
-	}
-	jsonEvent, err := dbEvent.Json()
-	if err != nil {
-		fmt.Printf("Failed to marshal event: %+v\n", err)
-		return false
-	}
-	zData := redis.Z{
-		Score:  float64(ts.UnixMilli()),
-		Member: jsonEvent,
-	}
-	res := s.redis.ZAdd(s.ctx, bucket, zData)
-	fmt.Println("Deleted: ", res.String())
-	return true
+	_, err := s.ApplyAction(action, ts)
+	return err == nil
 }
 
 func (s *Storage) Search(start, end int64) []DBBabyEvent {
@@ -176,97 +175,160 @@ func (s *Storage) Search(start, end int64) []DBBabyEvent {
 	return events
 }
 
+// SearchDay returns the events of a YYYY-MM-DD day in the user's time zone
+func (s *Storage) SearchDay(date string) ([]DBBabyEvent, error) {
+	start, end, err := DayBounds(date, s.loc)
+	if err != nil {
+		return nil, err
+	}
+	return s.Search(start, end), nil
+}
+
 func (s *Storage) Erase() {
 	s.redis.Del(s.ctx, s.keys["debug"])
+	s.dropAggregates()
 }
 
-func (s *Storage) ChangeTimestamp(event DBBabyEvent, newTimestamp int64) bool {
-	// need to create a copy and remote the old one
-	newEvent := event
-	newEvent.Timestamp = newTimestamp
-	jsonEvent, err := newEvent.Json()
-	if err != nil {
-		fmt.Printf("Failed to marshal event: %+v\n", err)
-		return false
-	}
-	bucket := s.keys["babyevents"]
-	// if GIN_MODE is not release, use debug bucket
-	if os.Getenv("GIN_MODE") != "release" {
-		bucket = s.keys["debug"]
-	}
+// ChangeTimestamp moves an event to a new timestamp. expectedRevision must
+// match the stored revision, otherwise ErrRevisionConflict is returned along
+// with the current version of the event.
+func (s *Storage) ChangeTimestamp(event DBBabyEvent, newTimestamp int64, expectedRevision int64) (*DBBabyEvent, error) {
+	return s.modifyEvent(event.Timestamp, expectedRevision, func(current *DBBabyEvent) *DBBabyEvent {
+		updated := *current
+		updated.Timestamp = newTimestamp
+		return &updated
+	})
+}
 
-	// remove the old event
-	s.Delete(event.Timestamp)
-	zData := redis.Z{
-		Score:  float64(newTimestamp),
-		Member: jsonEvent,
-	}
-	s.redis.ZAdd(s.ctx, bucket, zData)
-	return true
+// Delete removes the event stored at the given timestamp if its revision
+// still matches expectedRevision.
+func (s *Storage) Delete(timestamp int64, expectedRevision int64) (*DBBabyEvent, error) {
+	return s.modifyEvent(timestamp, expectedRevision, func(current *DBBabyEvent) *DBBabyEvent {
+		return nil
+	})
 }
 
-func (s *Storage) Delete(event int64) bool {
-	// if GIN_MODE is not release, use debug bucket
-	bucket := s.keys["babyevents"]
-	if os.Getenv("GIN_MODE") != "release" {
-		bucket = s.keys["debug"]
-	}
-	res := s.redis.ZRemRangeByScore(s.ctx, bucket, fmt.Sprintf("%d", event-100), fmt.Sprintf("%d", event+100))
-	fmt.Println("Deleted: ", res.String())
-	return res.Val() == 0
+// UpdateEvent renames the event stored at the given timestamp if its revision
+// still matches expectedRevision.
+func (s *Storage) UpdateEvent(timestamp int64, newAction string, expectedRevision int64) (*DBBabyEvent, error) {
+	return s.modifyEvent(timestamp, expectedRevision, func(current *DBBabyEvent) *DBBabyEvent {
+		updated := *current
+		updated.Name = newAction
+		return &updated
+	})
 }
 
-func (s *Storage) UpdateEvent(timestamp int64, newAction string) bool {
-	// if GIN_MODE is not release, use debug bucket
-	bucket := s.keys["babyevents"]
-	if os.Getenv("GIN_MODE") != "release" {
-		bucket = s.keys["debug"]
-	}
-	
-	// Get the existing event
-	events := s.redis.ZRangeByScore(s.ctx, bucket, &redis.ZRangeBy{
+// GetEvent returns the event stored at the given timestamp
+func (s *Storage) GetEvent(timestamp int64) (*DBBabyEvent, error) {
+	_, event, err := s.findEvent(s.redis, timestamp)
+	return event, err
+}
+
+// findEvent looks up the raw member and decoded event stored at timestamp.
+// Timestamps sent by the frontend may be off by a few milliseconds, so the
+// lookup tolerates ±100ms but prefers an exact match.
+func (s *Storage) findEvent(cmd redis.Cmdable, timestamp int64) (string, *DBBabyEvent, error) {
+	members, err := cmd.ZRangeByScore(s.ctx, s.eventsKey(), &redis.ZRangeBy{
 		Min: fmt.Sprintf("%d", timestamp-100),
 		Max: fmt.Sprintf("%d", timestamp+100),
-	})
-	
-	if events.Err() != nil || len(events.Val()) == 0 {
-		fmt.Printf("No event found at timestamp %d\n", timestamp)
-		return false
-	}
-	
-	// Parse the existing event
-	var existingEvent DBBabyEvent
-	err := json.Unmarshal([]byte(events.Val()[0]), &existingEvent)
+	}).Result()
 	if err != nil {
-		fmt.Printf("Error unmarshaling event: %v\n", err)
-		return false
+		return "", nil, err
 	}
-	
-	// Create updated event
-	updatedEvent := DBBabyEvent{
-		ID:        existingEvent.ID,
-		Timestamp: existingEvent.Timestamp,
-		Name:      newAction,
-		Author:    existingEvent.Author,
+
+	var found *DBBabyEvent
+	var foundMember string
+	for _, member := range members {
+		var e DBBabyEvent
+		if err := json.Unmarshal([]byte(member), &e); err != nil {
+			continue
+		}
+		if found == nil || e.Timestamp == timestamp {
+			found = &e
+			foundMember = member
+		}
+		if e.Timestamp == timestamp {
+			break
+		}
 	}
-	
-	// Remove old event
-	s.redis.ZRemRangeByScore(s.ctx, bucket, fmt.Sprintf("%d", timestamp-100), fmt.Sprintf("%d", timestamp+100))
-	
-	// Add updated event
-	updatedJSON, err := updatedEvent.Json()
-	if err != nil {
-		fmt.Printf("Error marshaling updated event: %v\n", err)
-		return false
+	if found == nil {
+		return "", nil, ErrEventNotFound
 	}
-	
-	res := s.redis.ZAdd(s.ctx, bucket, redis.Z{
-		Score:  float64(updatedEvent.Timestamp),
-		Member: updatedJSON,
-	})
-	
-	fmt.Printf("Updated event at timestamp %d from %s to %s\n", timestamp, existingEvent.Name, newAction)
-	return res.Err() == nil
+	return foundMember, found, nil
+}
+
+// modifyEvent applies change to the event stored at timestamp inside a
+// WATCH/MULTI transaction. A nil result from change deletes the event.
+// The returned event is the new version, or the current one on conflict.
+func (s *Storage) modifyEvent(timestamp int64, expectedRevision int64, change func(current *DBBabyEvent) *DBBabyEvent) (*DBBabyEvent, error) {
+	bucket := s.eventsKey()
+	var result *DBBabyEvent
+	var deleted bool
+	var previousTimestamp int64
+
+	txf := func(tx *redis.Tx) error {
+		member, current, err := s.findEvent(tx, timestamp)
+		if err != nil {
+			return err
+		}
+		if current.Revision != expectedRevision {
+			result = current
+			return ErrRevisionConflict
+		}
+		previousTimestamp = current.Timestamp
+
+		updated := change(current)
+		var updatedJSON string
+		if updated != nil {
+			updated.Revision = current.Revision + 1
+			updatedJSON, err = updated.Json()
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(s.ctx, bucket, member)
+			if updated != nil {
+				pipe.ZAdd(s.ctx, bucket, redis.Z{
+					Score:  float64(updated.Timestamp),
+					Member: updatedJSON,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if updated != nil {
+			result = updated
+		} else {
+			result = current
+			deleted = true
+		}
+		return nil
+	}
+
+	// Any write to the sorted set invalidates the WATCH, even for unrelated
+	// events, so retry a few times before giving up.
+	for i := 0; i < 3; i++ {
+		err := s.redis.Watch(s.ctx, txf, bucket)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		fmt.Printf("Event at timestamp %d modified (revision %d)\n", timestamp, expectedRevision)
+		s.invalidateAggregates(timestamp, result.Timestamp)
+		if deleted {
+			s.publish("deleted", result, result.Timestamp)
+		} else {
+			s.publish("updated", result, previousTimestamp)
+		}
+		return result, nil
+	}
+	return nil, ErrRevisionConflict
 }
 
 func (s *Storage) GetAllData() []DBBabyEvent {
@@ -293,6 +355,7 @@ func (s *Storage) EraseAll() bool {
 		bucket = s.keys["debug"]
 	}
 	res := s.redis.Del(s.ctx, bucket)
+	s.dropAggregates()
 	return res.Err() == nil
 }
 
@@ -305,160 +368,122 @@ type BabyStats struct {
 	RightBoobCount      int     `json:"right_boob_count"`     // Number of right breast feeds
 	LeftBoobDuration    int64   `json:"left_boob_duration"`   // Total left breast feed duration in milliseconds
 	RightBoobDuration   int64   `json:"right_boob_duration"`  // Total right breast feed duration in milliseconds
+	FeedCount           int     `json:"feed_count"`           // Number of feeds, a side switch within a feed counting once
+	MinFeedInterval     int64   `json:"min_feed_interval"`    // Shortest time between two feed starts in milliseconds
+	AverageFeedInterval int64   `json:"average_feed_interval"` // Average time between two feed starts in milliseconds
+	MaxFeedInterval     int64   `json:"max_feed_interval"`    // Longest time between two feed starts in milliseconds
+	ClusterFeedCount    int     `json:"cluster_feed_count"`   // Number of cluster feeding episodes
+	LeftBoobCountShare  float64 `json:"left_boob_count_share"` // Share of left breast feeds, from 0 to 1
+	LeftBoobDurationShare float64 `json:"left_boob_duration_share"` // Share of left breast feed duration, from 0 to 1
+	NextSide            string  `json:"next_side,omitempty"`  // Recommended side for the next feed, "leftBoob" or "rightBoob"
 	PeeCount           int     `json:"pee_count"`            // Number of pee events
 	PoopCount          int     `json:"poop_count"`           // Number of poop events
 	PeriodStart        int64   `json:"period_start"`         // Start timestamp of period
 	PeriodEnd          int64   `json:"period_end"`           // End timestamp of period
 }
 
+// statsLookback is how far back CalculateStats looks for sessions that were
+// already ongoing at the start of the period
+const statsLookback = 7 * 24 * 60 * 60 * 1000
+
+// aggregatesMinDays is the number of local days from which CalculateStats
+// composes the cached daily aggregates rather than reading the raw events
+const aggregatesMinDays = 3
+
 // CalculateStats computes statistics for baby events within a time range
 func (s *Storage) CalculateStats(start, end int64) *BabyStats {
-	events := s.Search(start, end)
-	
-	// Check if we need to look back for incomplete sleep periods
-	// If the first sleep-related event is "wake", baby was sleeping before the period
-	var sleepStartBeforePeriod int64
-	sleepRelatedEvents := make([]DBBabyEvent, 0)
-	
-	for _, event := range events {
-		if event.Name == "sleep" || event.Name == "wake" {
-			sleepRelatedEvents = append(sleepRelatedEvents, event)
+	if end-start >= aggregatesMinDays*24*60*60*1000 {
+		stats, err := s.statsFromAggregates(start, end)
+		if err == nil {
+			return stats
 		}
+		fmt.Printf("Daily aggregates unavailable, computing from events: %v\n", err)
 	}
-	
-	// If first sleep event is "wake", look for the corresponding "sleep" before the period
-	if len(sleepRelatedEvents) > 0 && sleepRelatedEvents[0].Name == "wake" {
-		// Search for events before the period start to find the sleep event
-		olderEvents := s.Search(start-7*24*60*60*1000, start) // Look back up to 7 days
-		
-		// Find the most recent sleep event before the period
-		for i := len(olderEvents) - 1; i >= 0; i-- {
-			if olderEvents[i].Name == "sleep" {
-				sleepStartBeforePeriod = olderEvents[i].Timestamp
-				break
-			}
-		}
-	}
-	
+
+	initial := s.sessionStateAt(start)
+	events := s.Search(start, end)
+	return computeStats(events, start, end, initial)
+}
+
+// sessionStateAt replays the events preceding ts (up to statsLookback) to
+// find the sessions ongoing at ts
+func (s *Storage) sessionStateAt(ts int64) sessionState {
+	olderEvents := s.Search(ts-statsLookback, ts-1)
+	_, state := pairSessions(sessionState{}, olderEvents, ts)
+	return state
+}
+
+// computeStats computes the stats of the events of [start, end], given the
+// sessions already ongoing at start
+func computeStats(events []DBBabyEvent, start, end int64, initial sessionState) *BabyStats {
+	sessions, _ := pairSessions(initial, events, end)
+	return statsFromSessions(sessions, events, start, end)
+}
+
+// statsFromSessions computes the stats of a period from paired sessions and
+// the events of the period. Sessions are clipped to the period: those
+// started before it add their duration within the period but don't count as
+// new sleeps or feeds, and open ones are counted up to end without being
+// part of the average sleep time.
+func statsFromSessions(sessions []Session, events []DBBabyEvent, start, end int64) *BabyStats {
 	stats := &BabyStats{
 		PeriodStart: start,
 		PeriodEnd:   end,
 	}
-	
-	var isSleeping bool
-	var lastSleepStart int64
-	var leftBoobStart, rightBoobStart int64
-	var isEatingLeft, isEatingRight bool
-	var sleepSessions []int64 // Track individual sleep session durations
-	
-	// If baby was sleeping before the period, initialize accordingly
-	if sleepStartBeforePeriod > 0 {
-		isSleeping = true
-		lastSleepStart = sleepStartBeforePeriod
-	}
-	
-	// Helper function to handle sleep interruption
-	handleSleepInterruption := func(eventTimestamp int64) {
-		if isSleeping {
-			sleepDuration := eventTimestamp - lastSleepStart
-			
-			// If sleep started before period, only count time within the period
-			if lastSleepStart < start {
-				sleepDuration = eventTimestamp - start
-				// Don't count as a completed session if it started before period
-			} else {
-				// Complete session within period
-				stats.SleepCount++
-				sleepSessions = append(sleepSessions, sleepDuration)
-			}
-			
-			stats.SleepTime += sleepDuration
-			isSleeping = false
+
+	var completedSleepTime int64
+	for _, session := range sessions {
+		from := session.Start
+		if from < start {
+			from = start
 		}
-	}
-	
-	// Process events chronologically
-	for _, event := range events {
-		switch event.Name {
+		to := end
+		if session.openUntil() < end {
+			to = session.openUntil()
+		}
+		duration := int64(0)
+		if to > from {
+			duration = to - from
+		}
+		startedInPeriod := session.Start >= start && session.Start < end
+
+		switch session.Type {
 		case "sleep":
-			if !isSleeping {
-				isSleeping = true
-				lastSleepStart = event.Timestamp
+			stats.SleepTime += duration
+			// Don't count ongoing sleep or sleep started before the period
+			// as a completed session for average calculation
+			if startedInPeriod && !session.Open {
+				stats.SleepCount++
+				completedSleepTime += session.Duration
 			}
-			
-		case "wake":
-			handleSleepInterruption(event.Timestamp)
-			
 		case "leftBoob":
-			if !isEatingLeft {
+			stats.LeftBoobDuration += duration
+			if startedInPeriod {
 				stats.LeftBoobCount++
-				leftBoobStart = event.Timestamp
-				isEatingLeft = true
-			}
-			// If was sleeping, add sleep time and stop sleeping
-			handleSleepInterruption(event.Timestamp)
-			
-		case "leftBoobStop":
-			if isEatingLeft {
-				stats.LeftBoobDuration += event.Timestamp - leftBoobStart
-				isEatingLeft = false
 			}
-			
 		case "rightBoob":
-			if !isEatingRight {
+			stats.RightBoobDuration += duration
+			if startedInPeriod {
 				stats.RightBoobCount++
-				rightBoobStart = event.Timestamp
-				isEatingRight = true
 			}
-			// If was sleeping, add sleep time and stop sleeping
-			handleSleepInterruption(event.Timestamp)
-			
-		case "rightBoobStop":
-			if isEatingRight {
-				stats.RightBoobDuration += event.Timestamp - rightBoobStart
-				isEatingRight = false
-			}
-			
+		}
+	}
+
+	for _, event := range events {
+		switch event.Name {
 		case "pee":
 			stats.PeeCount++
-			// If was sleeping, add sleep time and stop sleeping
-			handleSleepInterruption(event.Timestamp)
-			
 		case "poop":
 			stats.PoopCount++
-			// If was sleeping, add sleep time and stop sleeping
-			handleSleepInterruption(event.Timestamp)
-		}
-	}
-	
-	// Handle ongoing states at the end of the period
-	currentTime := end
-	if isSleeping {
-		ongoingSleepTime := currentTime - lastSleepStart
-		
-		// If sleep started before period, only count time within the period
-		if lastSleepStart < start {
-			ongoingSleepTime = currentTime - start
 		}
-		
-		stats.SleepTime += ongoingSleepTime
-		// Don't count ongoing sleep as a completed session for average calculation
-	}
-	if isEatingLeft {
-		stats.LeftBoobDuration += currentTime - leftBoobStart
-	}
-	if isEatingRight {
-		stats.RightBoobDuration += currentTime - rightBoobStart
 	}
-	
+
 	// Calculate average sleep time from completed sessions only
 	if stats.SleepCount > 0 {
-		totalCompletedSleepTime := int64(0)
-		for _, duration := range sleepSessions {
-			totalCompletedSleepTime += duration
-		}
-		stats.AverageSleepTime = totalCompletedSleepTime / int64(stats.SleepCount)
+		stats.AverageSleepTime = completedSleepTime / int64(stats.SleepCount)
 	}
-	
+
+	feedingStats(stats, sessions, start, end)
+
 	return stats
 }
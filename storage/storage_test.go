@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newTestStorage returns the storage of a throwaway user, skipping the test
+// when no Redis is configured
+func newTestStorage(t *testing.T) *Storage {
+	if os.Getenv("SCALINGO_REDIS_URL") == "" {
+		t.Skip("SCALINGO_REDIS_URL not set")
+	}
+	s := NewStorage("test-" + uuid.New().String())
+	if s == nil {
+		t.Skip("Redis unavailable")
+	}
+	t.Cleanup(func() { s.EraseAll() })
+	return s
+}
+
+func TestModifyEventRevision(t *testing.T) {
+	s := newTestStorage(t)
+	timestamp := int64(1000000000000)
+	saved, err := s.SaveEvent(DBBabyEvent{Timestamp: timestamp, Name: "sleep"})
+	if err != nil {
+		t.Fatalf("SaveEvent failed: %v", err)
+	}
+
+	updated, err := s.UpdateEvent(timestamp, "wake", saved.Revision)
+	if err != nil {
+		t.Fatalf("Expected the update at the current revision to succeed, got %v", err)
+	}
+	if updated.Name != "wake" || updated.Revision != saved.Revision+1 {
+		t.Errorf("Expected wake at revision %d, got %+v", saved.Revision+1, updated)
+	}
+
+	// A client still holding the first revision
+	current, err := s.UpdateEvent(timestamp, "sleep", saved.Revision)
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("Expected ErrRevisionConflict for a stale revision, got %v", err)
+	}
+	if current == nil || current.Name != "wake" || current.Revision != updated.Revision {
+		t.Errorf("Expected the current version on conflict, got %+v", current)
+	}
+	if _, err := s.Delete(timestamp, saved.Revision); !errors.Is(err, ErrRevisionConflict) {
+		t.Errorf("Expected ErrRevisionConflict deleting at a stale revision, got %v", err)
+	}
+
+	if _, err := s.Delete(timestamp, updated.Revision); err != nil {
+		t.Errorf("Expected the delete at the current revision to succeed, got %v", err)
+	}
+	if _, err := s.Delete(timestamp, updated.Revision); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("Expected ErrEventNotFound once deleted, got %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// MaxSyncOperations bounds the size of a sync batch
+	MaxSyncOperations = 500
+	// syncResultTTL is how long the result of an operation is kept to answer
+	// retries of the same batch
+	syncResultTTL = 30 * 24 * time.Hour
+	// syncClaimTimeout is after how long an operation claimed by an attempt
+	// which never stored its result can be applied again
+	syncClaimTimeout = time.Minute
+	// syncPendingPrefix marks an operation being applied, followed by the
+	// claim time
+	syncPendingPrefix = "pending:"
+)
+
+// SyncOperation is an operation recorded offline by a client. ClientID is
+// generated by the client and makes retries idempotent.
+type SyncOperation struct {
+	ClientID  string `json:"client_id"`
+	Type      string `json:"type"`      // "remote", "add", "update" or "delete"
+	Action    string `json:"action"`    // Remote action or event name to add, new name for an update
+	Timestamp int64  `json:"timestamp"` // Local time of the tap, or timestamp of the event to update or delete
+	Revision  int64  `json:"revision"`  // Revision the client last saw, for updates and deletes
+	Note      string `json:"note,omitempty"`
+}
+
+// SyncConflict describes how the server resolved an operation which didn't
+// apply as recorded
+type SyncConflict struct {
+	Reason   string       `json:"reason"` // "action_resolved", "revision_conflict", "not_found" or "duplicate"
+	Expected string       `json:"expected,omitempty"`
+	Applied  string       `json:"applied,omitempty"`
+	Current  *DBBabyEvent `json:"current,omitempty"` // Server version of the event
+}
+
+// SyncResult is the outcome of an operation
+type SyncResult struct {
+	ClientID    string        `json:"client_id"`
+	Status      string        `json:"status"` // "applied", "conflict" or "error"
+	Events      []DBBabyEvent `json:"events,omitempty"`
+	Revalidated []DBBabyEvent `json:"revalidated,omitempty"` // Later events changed by a backdated remote action
+	Issues      []Issue       `json:"issues,omitempty"`      // Invalid sequences a backdated remote action left
+	Conflict    *SyncConflict `json:"conflict,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Replayed    bool          `json:"replayed,omitempty"` // Result of an earlier attempt of the same operation
+}
+
+// syncResultsKey returns the prefix of the keys holding the operation
+// results, followed by the client ID
+func (s *Storage) syncResultsKey() string {
+	return s.eventsKey() + ":sync"
+}
+
+// syncResultStore keeps the result of each operation, or a pending marker
+// while it is being applied, by client ID
+type syncResultStore interface {
+	// claim sets value unless the operation has one, returned instead
+	claim(clientID, value string) (bool, string, error)
+	// replace sets value if the operation still has previous
+	replace(clientID, previous, value string) (bool, error)
+	set(clientID, value string) error
+	get(clientID string) (string, error)
+	remove(clientID string) error
+}
+
+// redisSyncResults stores each result under its own key, expiring
+// syncResultTTL after it was written
+type redisSyncResults struct {
+	s *Storage
+}
+
+func (r redisSyncResults) key(clientID string) string {
+	return r.s.syncResultsKey() + ":" + clientID
+}
+
+func (r redisSyncResults) claim(clientID, value string) (bool, string, error) {
+	for i := 0; i < 3; i++ {
+		claimed, err := r.s.redis.SetNX(r.s.ctx, r.key(clientID), value, syncResultTTL).Result()
+		if err != nil || claimed {
+			return claimed, "", err
+		}
+		current, err := r.s.redis.Get(r.s.ctx, r.key(clientID)).Result()
+		if err == redis.Nil {
+			continue // Expired meanwhile
+		}
+		return false, current, err
+	}
+	return false, "", redis.TxFailedErr
+}
+
+func (r redisSyncResults) replace(clientID, previous, value string) (bool, error) {
+	replaced := false
+	err := r.s.redis.Watch(r.s.ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(r.s.ctx, r.key(clientID)).Result()
+		if err != nil || current != previous {
+			return err
+		}
+		_, err = tx.TxPipelined(r.s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(r.s.ctx, r.key(clientID), value, syncResultTTL)
+			return nil
+		})
+		replaced = err == nil
+		return err
+	}, r.key(clientID))
+	if err == redis.TxFailedErr || err == redis.Nil {
+		return false, nil
+	}
+	return replaced, err
+}
+
+func (r redisSyncResults) set(clientID, value string) error {
+	return r.s.redis.Set(r.s.ctx, r.key(clientID), value, syncResultTTL).Err()
+}
+
+func (r redisSyncResults) get(clientID string) (string, error) {
+	return r.s.redis.Get(r.s.ctx, r.key(clientID)).Result()
+}
+
+func (r redisSyncResults) remove(clientID string) error {
+	return r.s.redis.Del(r.s.ctx, r.key(clientID)).Err()
+}
+
+// Sync applies the operations in order. An operation already applied by an
+// earlier attempt isn't applied again, its stored result is returned.
+func (s *Storage) Sync(operations []SyncOperation) []SyncResult {
+	return syncOperations(operations, redisSyncResults{s}, s.applySyncOperation, time.Now)
+}
+
+func syncOperations(operations []SyncOperation, store syncResultStore, apply func(SyncOperation) SyncResult, now func() time.Time) []SyncResult {
+	results := make([]SyncResult, 0, len(operations))
+	for _, operation := range operations {
+		if operation.ClientID == "" {
+			results = append(results, SyncResult{Status: "error", Error: "client_id required"})
+			continue
+		}
+
+		// Claim the operation, a concurrent retry gets the stored result
+		claimed, err := claimSyncOperation(store, operation.ClientID, now())
+		if err != nil {
+			results = append(results, SyncResult{ClientID: operation.ClientID, Status: "error", Error: err.Error()})
+			continue
+		}
+		if !claimed {
+			results = append(results, storedSyncResult(store, operation.ClientID))
+			continue
+		}
+
+		result := apply(operation)
+		if result.Status == "error" {
+			// Nothing was written, a retry may succeed
+			store.remove(operation.ClientID)
+		} else if data, err := json.Marshal(result); err == nil {
+			store.set(operation.ClientID, string(data))
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// claimSyncOperation marks the operation as being applied. It returns false
+// when it already was, unless that attempt was interrupted before storing
+// its result for longer than syncClaimTimeout.
+func claimSyncOperation(store syncResultStore, clientID string, now time.Time) (bool, error) {
+	pending := fmt.Sprintf("%s%d", syncPendingPrefix, now.UnixMilli())
+	claimed, current, err := store.claim(clientID, pending)
+	if err != nil || claimed {
+		return claimed, err
+	}
+
+	if !strings.HasPrefix(current, syncPendingPrefix) {
+		return false, nil
+	}
+	since, _ := strconv.ParseInt(strings.TrimPrefix(current, syncPendingPrefix), 10, 64)
+	if now.Sub(time.UnixMilli(since)) < syncClaimTimeout {
+		return false, nil
+	}
+	// Only one of the attempts taking over succeeds
+	return store.replace(clientID, current, pending)
+}
+
+// storedSyncResult returns the stored result of an operation. An operation
+// still being applied by a concurrent request is reported as applied
+// without its events, the merged state includes them.
+func storedSyncResult(store syncResultStore, clientID string) SyncResult {
+	result := SyncResult{ClientID: clientID, Status: "applied"}
+	data, err := store.get(clientID)
+	if err == nil && !strings.HasPrefix(data, syncPendingPrefix) {
+		json.Unmarshal([]byte(data), &result)
+	}
+	result.Replayed = true
+	return result
+}
+
+func (s *Storage) applySyncOperation(operation SyncOperation) SyncResult {
+	result := SyncResult{ClientID: operation.ClientID, Status: "applied"}
+	fail := func(err error) SyncResult {
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	// Operations creating events must happen within the bounds of the remote
+	// actions, a zero or far-future timestamp is a client bug
+	if operation.Type == "remote" || operation.Type == "add" {
+		if err := ValidateActionTime(operation.Timestamp, time.Now()); err != nil {
+			return fail(err)
+		}
+	}
+
+	switch operation.Type {
+	case "remote":
+		applied, err := s.ApplyAction(operation.Action, time.UnixMilli(operation.Timestamp))
+		if err != nil {
+			return fail(err)
+		}
+		result.Events = applied.Events
+		result.Revalidated = applied.Revalidated
+		result.Issues = applied.Issues
+		if applied := applied.Events[len(applied.Events)-1].Name; applied != operation.Action {
+			result.Conflict = &SyncConflict{Reason: "action_resolved", Expected: operation.Action, Applied: applied}
+		}
+	case "add":
+		// The same event may have been logged by the other parent
+		for _, existing := range s.Search(operation.Timestamp, operation.Timestamp) {
+			if existing.Name == operation.Action {
+				existing := existing
+				result.Status = "conflict"
+				result.Conflict = &SyncConflict{Reason: "duplicate", Current: &existing}
+				return result
+			}
+		}
+		saved, err := s.SaveEvent(DBBabyEvent{Timestamp: operation.Timestamp, Name: operation.Action, Note: operation.Note})
+		if err != nil {
+			return fail(err)
+		}
+		result.Events = []DBBabyEvent{*saved}
+	case "update", "delete":
+		var event *DBBabyEvent
+		var err error
+		if operation.Type == "update" {
+			event, err = s.UpdateEvent(operation.Timestamp, operation.Action, operation.Revision)
+		} else {
+			event, err = s.Delete(operation.Timestamp, operation.Revision)
+		}
+		switch {
+		case errors.Is(err, ErrRevisionConflict):
+			// The server version wins, the client gets it back
+			result.Status = "conflict"
+			result.Conflict = &SyncConflict{Reason: "revision_conflict", Current: event}
+		case errors.Is(err, ErrEventNotFound):
+			result.Status = "conflict"
+			result.Conflict = &SyncConflict{Reason: "not_found"}
+		case err != nil:
+			return fail(err)
+		default:
+			result.Events = []DBBabyEvent{*event}
+		}
+	default:
+		return fail(fmt.Errorf("unknown operation type %q", operation.Type))
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memorySyncResults is a syncResultStore held in a map
+type memorySyncResults map[string]string
+
+func (m memorySyncResults) claim(clientID, value string) (bool, string, error) {
+	if current, ok := m[clientID]; ok {
+		return false, current, nil
+	}
+	m[clientID] = value
+	return true, "", nil
+}
+
+func (m memorySyncResults) replace(clientID, previous, value string) (bool, error) {
+	if m[clientID] != previous {
+		return false, nil
+	}
+	m[clientID] = value
+	return true, nil
+}
+
+func (m memorySyncResults) set(clientID, value string) error {
+	m[clientID] = value
+	return nil
+}
+
+func (m memorySyncResults) get(clientID string) (string, error) {
+	value, ok := m[clientID]
+	if !ok {
+		return "", fmt.Errorf("not found")
+	}
+	return value, nil
+}
+
+func (m memorySyncResults) remove(clientID string) error {
+	delete(m, clientID)
+	return nil
+}
+
+// countingApply applies every operation as one event at its timestamp and
+// counts the calls per client ID
+func countingApply(calls map[string]int) func(SyncOperation) SyncResult {
+	return func(operation SyncOperation) SyncResult {
+		calls[operation.ClientID]++
+		return SyncResult{
+			ClientID: operation.ClientID,
+			Status:   "applied",
+			Events:   []DBBabyEvent{{Timestamp: operation.Timestamp, Name: operation.Action}},
+		}
+	}
+}
+
+func TestSyncReplay(t *testing.T) {
+	store := memorySyncResults{}
+	calls := make(map[string]int)
+	now := func() time.Time { return time.UnixMilli(1000000000000) }
+	operations := []SyncOperation{
+		{ClientID: "a", Type: "remote", Action: "sleep", Timestamp: 1000000000000},
+		{ClientID: "b", Type: "remote", Action: "pee", Timestamp: 1000000060000},
+	}
+
+	first := syncOperations(operations, store, countingApply(calls), now)
+	second := syncOperations(operations, store, countingApply(calls), now)
+
+	for i, operation := range operations {
+		if calls[operation.ClientID] != 1 {
+			t.Errorf("Expected %s to be applied once, got %d", operation.ClientID, calls[operation.ClientID])
+		}
+		if first[i].Replayed || !second[i].Replayed {
+			t.Errorf("Expected only the retry of %s to be replayed, got %v then %v", operation.ClientID, first[i].Replayed, second[i].Replayed)
+		}
+		if len(second[i].Events) != 1 || second[i].Events[0] != first[i].Events[0] {
+			t.Errorf("Expected the stored events of %s, got %+v", operation.ClientID, second[i].Events)
+		}
+	}
+}
+
+func TestSyncConcurrentClaim(t *testing.T) {
+	now := time.UnixMilli(1000000000000)
+	// Another request claimed the operation and is still applying it
+	store := memorySyncResults{"a": fmt.Sprintf("%s%d", syncPendingPrefix, now.Add(-10*time.Second).UnixMilli())}
+	calls := make(map[string]int)
+
+	results := syncOperations([]SyncOperation{{ClientID: "a", Type: "remote", Action: "sleep", Timestamp: now.UnixMilli()}}, store, countingApply(calls), func() time.Time { return now })
+
+	if calls["a"] != 0 {
+		t.Errorf("Expected the operation not to be applied twice")
+	}
+	if results[0].Status != "applied" || !results[0].Replayed || len(results[0].Events) != 0 {
+		t.Errorf("Expected an applied result without events, got %+v", results[0])
+	}
+}
+
+func TestSyncClaimTimeout(t *testing.T) {
+	now := time.UnixMilli(1000000000000)
+	// The request which claimed the operation died before storing its result
+	store := memorySyncResults{"a": fmt.Sprintf("%s%d", syncPendingPrefix, now.Add(-syncClaimTimeout-time.Second).UnixMilli())}
+	calls := make(map[string]int)
+
+	results := syncOperations([]SyncOperation{{ClientID: "a", Type: "remote", Action: "sleep", Timestamp: now.UnixMilli()}}, store, countingApply(calls), func() time.Time { return now })
+
+	if calls["a"] != 1 || results[0].Replayed {
+		t.Errorf("Expected the stale claim to be taken over and applied, got %d calls and %+v", calls["a"], results[0])
+	}
+	if stored, _ := store.get("a"); stored == "" || strings.HasPrefix(stored, syncPendingPrefix) {
+		t.Errorf("Expected the result to be stored, got %q", stored)
+	}
+}
+
+func TestSyncErrorRetried(t *testing.T) {
+	store := memorySyncResults{}
+	now := func() time.Time { return time.UnixMilli(1000000000000) }
+	operation := SyncOperation{ClientID: "a", Type: "remote", Action: "sleep", Timestamp: 1000000000000}
+	failing := func(operation SyncOperation) SyncResult {
+		return SyncResult{ClientID: operation.ClientID, Status: "error", Error: "redis down"}
+	}
+
+	results := syncOperations([]SyncOperation{operation}, store, failing, now)
+	if results[0].Status != "error" {
+		t.Fatalf("Expected an error, got %+v", results[0])
+	}
+	if _, ok := store["a"]; ok {
+		t.Errorf("Expected the claim of the failed operation to be removed")
+	}
+
+	calls := make(map[string]int)
+	results = syncOperations([]SyncOperation{operation}, store, countingApply(calls), now)
+	if calls["a"] != 1 || results[0].Status != "applied" || results[0].Replayed {
+		t.Errorf("Expected the retry to apply the operation, got %d calls and %+v", calls["a"], results[0])
+	}
+}
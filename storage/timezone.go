@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	// Embed the time zone database, the container images don't ship one
+	_ "time/tzdata"
+)
+
+// DefaultTimeZone is used for users who haven't chosen a time zone. It can
+// be overridden with the DEFAULT_TIMEZONE environment variable.
+const DefaultTimeZone = "Europe/Paris"
+
+// DefaultLocation returns the location of DEFAULT_TIMEZONE, or Europe/Paris
+func DefaultLocation() *time.Location {
+	if name := os.Getenv("DEFAULT_TIMEZONE"); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+		fmt.Printf("Invalid DEFAULT_TIMEZONE %q, using %s\n", name, DefaultTimeZone)
+	}
+	loc, err := time.LoadLocation(DefaultTimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Location returns the user's time zone, falling back to the default one
+func (u *User) Location() *time.Location {
+	if u.TimeZone != "" {
+		if loc, err := time.LoadLocation(u.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return DefaultLocation()
+}
+
+// locationCacheTTL is how long the time zone of a user is kept in memory.
+// It bounds how long another instance keeps the previous zone after a
+// change.
+const locationCacheTTL = 5 * time.Minute
+
+type cachedLocation struct {
+	loc     *time.Location
+	fetched time.Time
+}
+
+// locationCache keeps the time zone of the users, so that requests don't
+// read the whole user just to find their day boundaries
+type locationCache struct {
+	mutex     sync.RWMutex
+	locations map[string]cachedLocation
+}
+
+var userLocations = &locationCache{locations: make(map[string]cachedLocation)}
+
+// get returns the cached location of the user, calling fetch when it is
+// missing or older than locationCacheTTL. A failed fetch isn't cached.
+func (cache *locationCache) get(userID string, now time.Time, fetch func() (*time.Location, error)) *time.Location {
+	cache.mutex.RLock()
+	cached, ok := cache.locations[userID]
+	cache.mutex.RUnlock()
+	if ok && now.Sub(cached.fetched) < locationCacheTTL {
+		return cached.loc
+	}
+
+	loc, err := fetch()
+	if err != nil {
+		return DefaultLocation()
+	}
+	cache.set(userID, loc, now)
+	return loc
+}
+
+func (cache *locationCache) set(userID string, loc *time.Location, now time.Time) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.locations[userID] = cachedLocation{loc: loc, fetched: now}
+}
+
+// UserLocation returns the time zone of the user, read from storage at most
+// once per locationCacheTTL
+func (us *UserStorage) UserLocation(userID string) *time.Location {
+	return userLocations.get(userID, time.Now(), func() (*time.Location, error) {
+		user, err := us.GetUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		return user.Location(), nil
+	})
+}
+
+// StartOfDay returns local midnight of the day containing t in loc
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// StartOfWeek returns local midnight of the Sunday starting the week of t
+func StartOfWeek(t time.Time, loc *time.Location) time.Time {
+	day := StartOfDay(t, loc)
+	return day.AddDate(0, 0, -int(day.Weekday()))
+}
+
+// StartOfMonth returns local midnight of the first day of the month of t
+func StartOfMonth(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+}
+
+// AtHour returns the given local hour on the day of t. Unlike adding hours
+// to midnight, the wall clock hour is kept on DST transition days.
+func AtHour(t time.Time, hour int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, t.Location())
+}
+
+// DayBounds returns the first and last millisecond of a YYYY-MM-DD day in
+// loc. Days are 23 or 25 hours long across DST transitions.
+func DayBounds(date string, loc *time.Location) (int64, int64, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return 0, 0, err
+	}
+	return day.UnixMilli(), day.AddDate(0, 0, 1).UnixMilli() - 1, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDayBounds(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("Failed to load Europe/Paris: %v", err)
+	}
+
+	tests := []struct {
+		date     string
+		expected time.Duration
+	}{
+		{"2024-01-15", 24 * time.Hour},
+		{"2024-03-31", 23 * time.Hour}, // Spring forward
+		{"2024-10-27", 25 * time.Hour}, // Fall back
+	}
+	for _, tt := range tests {
+		start, end, err := DayBounds(tt.date, paris)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", tt.date, err)
+		}
+		if got := time.Duration(end-start+1) * time.Millisecond; got != tt.expected {
+			t.Errorf("%s: expected day length %v, got %v", tt.date, tt.expected, got)
+		}
+		if local := time.UnixMilli(start).In(paris); local.Hour() != 0 || local.Minute() != 0 {
+			t.Errorf("%s: expected local midnight, got %v", tt.date, local)
+		}
+	}
+
+	if _, _, err := DayBounds("15/01/2024", paris); err == nil {
+		t.Error("Expected an error for an invalid date")
+	}
+}
+
+func TestStartOfWeek(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+
+	// Wednesday 00:30 in Paris is still Tuesday in UTC
+	now := time.Date(2024, 4, 3, 0, 30, 0, 0, paris)
+	start := StartOfWeek(now, paris)
+	expected := time.Date(2024, 3, 31, 0, 0, 0, 0, paris)
+	if !start.Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, start)
+	}
+}
+
+func TestLocationCache(t *testing.T) {
+	kolkata, _ := time.LoadLocation("Asia/Kolkata")
+	cache := &locationCache{locations: make(map[string]cachedLocation)}
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	fetches := 0
+	fetch := func() (*time.Location, error) {
+		fetches++
+		return kolkata, nil
+	}
+
+	cache.get("u1", now, fetch)
+	if loc := cache.get("u1", now.Add(time.Minute), fetch); loc != kolkata || fetches != 1 {
+		t.Errorf("Expected the cached zone without a second read, got %v after %d reads", loc, fetches)
+	}
+	if cache.get("u1", now.Add(locationCacheTTL), fetch); fetches != 2 {
+		t.Errorf("Expected the zone read again once expired, got %d reads", fetches)
+	}
+
+	// A change made on this instance is seen at once
+	cache.set("u1", time.UTC, now)
+	if loc := cache.get("u1", now, fetch); loc != time.UTC {
+		t.Errorf("Expected the new zone, got %v", loc)
+	}
+
+	failing := func() (*time.Location, error) { return nil, fmt.Errorf("unavailable") }
+	if loc := cache.get("u2", now, failing); loc.String() != DefaultLocation().String() {
+		t.Errorf("Expected the default zone when the user can't be read, got %v", loc)
+	}
+	if _, ok := cache.locations["u2"]; ok {
+		t.Error("Expected a failed read not to be cached")
+	}
+}
@@ -24,6 +24,10 @@ type User struct {
 	Email         string `json:"email,omitempty"` 
 	EmailVerified bool   `json:"email_verified"`
 	Created       int64  `json:"created"`
+	TimeZone      string `json:"time_zone,omitempty"` // IANA name, e.g. "Europe/Paris"
+	Reminders     bool   `json:"reminders,omitempty"` // Email reminders before the predicted feed and nap
+	BirthDate     string `json:"birth_date,omitempty"` // YYYY-MM-DD, for the age-based norms
+	AlertEmails   bool   `json:"alert_emails,omitempty"` // Daily email when the previous day is outside the norms
 }
 
 type UserClaims struct {
@@ -90,7 +94,11 @@ func (us *UserStorage) CreateUserWithRole(username, password, role string) (*Use
 		return nil, err
 	}
 
-	err = us.redis.HSet(us.ctx, "users", username, string(userData)).Err()
+	_, err = us.redis.TxPipelined(us.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(us.ctx, "users", username, string(userData))
+		pipe.HSet(us.ctx, "user_ids", user.ID, username)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -206,6 +214,33 @@ func (us *UserStorage) GetUser(username string) (*User, error) {
 	return &user, nil
 }
 
+// GetUserByID looks the user up through the user_ids index, mapping IDs to
+// usernames. Users created before the index are found by a scan once, which
+// adds them to it.
+func (us *UserStorage) GetUserByID(userID string) (*User, error) {
+	username, err := us.redis.HGet(us.ctx, "user_ids", userID).Result()
+	if err == nil {
+		return us.GetUser(username)
+	}
+	if err != redis.Nil {
+		return nil, err
+	}
+
+	users, err := us.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if user.ID == userID {
+			us.redis.HSet(us.ctx, "user_ids", user.ID, user.Username)
+			return user, nil
+		}
+	}
+
+	return nil, fmt.Errorf("user not found")
+}
+
 func (us *UserStorage) EnsureAdminUser() error {
 	adminUsername := os.Getenv("ADMIN_USERNAME")
 	adminPassword := os.Getenv("ADMIN_PASSWORD")
@@ -368,6 +403,91 @@ func (us *UserStorage) SetUserEmailUnverified(userID, email string) error {
 	return nil
 }
 
+func (us *UserStorage) SetUserTimeZone(userID, timeZone string) error {
+	if _, err := time.LoadLocation(timeZone); err != nil || timeZone == "" {
+		return fmt.Errorf("invalid time zone")
+	}
+
+	err := us.updateUser(userID, func(user *User) {
+		user.TimeZone = timeZone
+	})
+	if err != nil {
+		return err
+	}
+	loc, _ := time.LoadLocation(timeZone)
+	userLocations.set(userID, loc, time.Now())
+
+	fmt.Printf("Time zone %s set for user %s\n", timeZone, userID)
+	return nil
+}
+
+func (us *UserStorage) SetUserReminders(userID string, enabled bool) error {
+	err := us.updateUser(userID, func(user *User) {
+		user.Reminders = enabled
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reminders set to %t for user %s\n", enabled, userID)
+	return nil
+}
+
+func (us *UserStorage) SetUserBirthDate(userID, birthDate string) error {
+	if _, err := time.Parse("2006-01-02", birthDate); err != nil {
+		return fmt.Errorf("invalid birth date")
+	}
+
+	err := us.updateUser(userID, func(user *User) {
+		user.BirthDate = birthDate
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Birth date %s set for user %s\n", birthDate, userID)
+	return nil
+}
+
+func (us *UserStorage) SetUserAlertEmails(userID string, enabled bool) error {
+	err := us.updateUser(userID, func(user *User) {
+		user.AlertEmails = enabled
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Alert emails set to %t for user %s\n", enabled, userID)
+	return nil
+}
+
+// updateUser applies update to the stored user record. The record is read
+// with the password hash, which GetAllUsers strips.
+func (us *UserStorage) updateUser(userID string, update func(user *User)) error {
+	targetUser, err := us.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	userData := us.redis.HGet(us.ctx, "users", targetUser.Username)
+	if userData.Err() != nil {
+		return userData.Err()
+	}
+	var fullUser User
+	err = json.Unmarshal([]byte(userData.Val()), &fullUser)
+	if err != nil {
+		return err
+	}
+
+	update(&fullUser)
+
+	data, err := json.Marshal(fullUser)
+	if err != nil {
+		return err
+	}
+
+	return us.redis.HSet(us.ctx, "users", fullUser.Username, string(data)).Err()
+}
+
 func (us *UserStorage) SendVerificationEmail(email string) (string, error) {
 	emailService := NewEmailService()
 	if emailService == nil {
@@ -471,11 +591,20 @@ func (us *UserStorage) DeleteUserAccount(userID string) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	us.redis.HDel(us.ctx, "user_ids", userID)
 
 	// Delete user's event data
 	userDataKeys := []string{
 		fmt.Sprintf("user:%s:ts_events", userID),
 		fmt.Sprintf("user:%s:ts_debug", userID),
+		fmt.Sprintf("user:%s:ts_events:daily", userID),
+		fmt.Sprintf("user:%s:ts_events:daily_version", userID),
+		fmt.Sprintf("user:%s:ts_debug:daily", userID),
+		fmt.Sprintf("user:%s:ts_debug:daily_version", userID),
+		fmt.Sprintf("user:%s:ts_events:audit", userID),
+		fmt.Sprintf("user:%s:ts_debug:audit", userID),
+		fmt.Sprintf("user:%s:ts_events:sync", userID),
+		fmt.Sprintf("user:%s:ts_debug:sync", userID),
 	}
 
 	for _, key := range userDataKeys {
@@ -485,12 +614,32 @@ func (us *UserStorage) DeleteUserAccount(userID string) error {
 		}
 	}
 
+	// Sync results have one key per operation
+	for _, pattern := range []string{
+		fmt.Sprintf("user:%s:ts_events:sync:*", userID),
+		fmt.Sprintf("user:%s:ts_debug:sync:*", userID),
+	} {
+		iter := us.redis.Scan(us.ctx, 0, pattern, 100).Iterator()
+		for iter.Next(us.ctx) {
+			us.redis.Del(us.ctx, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			fmt.Printf("Warning: failed to delete sync results: %v\n", err)
+		}
+	}
+
 	// Clean up any pending verification codes for this user's email
 	if targetUser.Email != "" {
 		verificationKey := fmt.Sprintf("email_verification:%s", targetUser.Email)
 		us.redis.Del(us.ctx, verificationKey)
 	}
 
+	// Revoke the calendar subscription
+	calendarKey := fmt.Sprintf("user:%s:calendar_token", userID)
+	if token, err := us.redis.Get(us.ctx, calendarKey).Result(); err == nil {
+		us.redis.Del(us.ctx, fmt.Sprintf("calendar_token:%s", token), calendarKey)
+	}
+
 	fmt.Printf("User account deleted: %s (ID: %s)\n", targetUser.Username, userID)
 	return nil
 }
@@ -600,4 +749,87 @@ func (us *UserStorage) ResetPassword(token, newPassword string) error {
 
 	fmt.Printf("Password reset successful for user %s (email %s now verified)\n", targetUser.Username, email)
 	return nil
-}
\ No newline at end of file
+}
+
+// Calendar subscription functions
+
+// GetCalendarToken returns the secret token of the user's calendar feed,
+// creating one on first use
+func (us *UserStorage) GetCalendarToken(userID string) (string, error) {
+	token, err := us.redis.Get(us.ctx, fmt.Sprintf("user:%s:calendar_token", userID)).Result()
+	if err == nil {
+		return token, nil
+	}
+	if err != redis.Nil {
+		return "", err
+	}
+	return us.RotateCalendarToken(userID)
+}
+
+// RotateCalendarToken replaces the calendar feed token, so that previously
+// shared URLs stop working
+func (us *UserStorage) RotateCalendarToken(userID string) (string, error) {
+	userKey := fmt.Sprintf("user:%s:calendar_token", userID)
+	if oldToken, err := us.redis.Get(us.ctx, userKey).Result(); err == nil {
+		us.redis.Del(us.ctx, fmt.Sprintf("calendar_token:%s", oldToken))
+	}
+
+	token := us.GeneratePasswordResetToken()
+	_, err := us.redis.TxPipelined(us.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(us.ctx, userKey, token, 0)
+		pipe.Set(us.ctx, fmt.Sprintf("calendar_token:%s", token), userID, 0)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store calendar token: %w", err)
+	}
+	fmt.Printf("Calendar token rotated for user %s\n", userID)
+	return token, nil
+}
+
+// GetUserIDByCalendarToken resolves a calendar feed token to its user
+func (us *UserStorage) GetUserIDByCalendarToken(token string) (string, error) {
+	userID, err := us.redis.Get(us.ctx, fmt.Sprintf("calendar_token:%s", token)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", fmt.Errorf("calendar token not found")
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+// StreamTokenTTL is how long a stream token may wait before being used
+const StreamTokenTTL = time.Minute
+
+// CreateStreamToken issues a single-use token opening the event stream on
+// behalf of the authenticated user. EventSource can't set headers, so the
+// stream takes it in the URL instead of the long-lived JWT.
+func (us *UserStorage) CreateStreamToken(claims *UserClaims) (string, error) {
+	data, err := json.Marshal(UserClaims{UserID: claims.UserID, Username: claims.Username, Role: claims.Role})
+	if err != nil {
+		return "", err
+	}
+	token := us.GeneratePasswordResetToken()
+	if err := us.redis.Set(us.ctx, fmt.Sprintf("stream_token:%s", token), data, StreamTokenTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store stream token: %w", err)
+	}
+	return token, nil
+}
+
+// ConsumeStreamToken resolves a stream token to the user it was issued for
+// and invalidates it
+func (us *UserStorage) ConsumeStreamToken(token string) (*UserClaims, error) {
+	data, err := us.redis.GetDel(us.ctx, fmt.Sprintf("stream_token:%s", token)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("stream token not found")
+		}
+		return nil, err
+	}
+	var claims UserClaims
+	if err := json.Unmarshal([]byte(data), &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultNightWeight is how much a task done at night counts compared to
+	// the same task during the day
+	DefaultNightWeight = 2.0
+	// diaperMergeGap groups the pee and poop of one diaper change logged by
+	// the same caregiver
+	diaperMergeGap = 5 * 60 * 1000
+)
+
+// CaregiverStats are the tasks done by one author over a period
+type CaregiverStats struct {
+	Author             string  `json:"author"`
+	FeedCount          int     `json:"feed_count"`           // Feeds started, a side switch counting once
+	NightFeedCount     int     `json:"night_feed_count"`     // Feeds started within the night window
+	DiaperCount        int     `json:"diaper_count"`         // Diaper changes, a pee and poop logged together counting once
+	NightDiaperCount   int     `json:"night_diaper_count"`   // Diaper changes within the night window
+	SettlingCount      int     `json:"settling_count"`       // Sleeps started, putting the baby down
+	NightSettlingCount int     `json:"night_settling_count"` // Sleeps started within the night window
+	Score              float64 `json:"score"`                // Tasks, the night ones counting NightWeight
+	Share              float64 `json:"share"`                // Share of the total score, from 0 to 1
+}
+
+// WorkloadReport splits the feeds, diaper changes and settlings of a period
+// by author
+type WorkloadReport struct {
+	Caregivers  []CaregiverStats `json:"caregivers"` // Highest score first
+	NightWindow NightWindow      `json:"night_window"`
+	NightWeight float64          `json:"night_weight"`
+	PeriodStart int64            `json:"period_start"`
+	PeriodEnd   int64            `json:"period_end"`
+}
+
+// CalculateWorkload computes the workload report of a period, with the same
+// session pairing as CalculateStats
+func (s *Storage) CalculateWorkload(start, end int64, window NightWindow, nightWeight float64) *WorkloadReport {
+	events := s.Search(start, end)
+	sessions, _ := pairSessions(s.sessionStateAt(start), events, end)
+	return computeWorkload(sessions, events, start, end, window, nightWeight, s.loc)
+}
+
+// computeWorkload credits each task to the author of the event starting it:
+// the feed start, the pee or poop, and the sleep start. Events without
+// author are credited to "None".
+func computeWorkload(sessions []Session, events []DBBabyEvent, start, end int64, window NightWindow, nightWeight float64, loc *time.Location) *WorkloadReport {
+	report := &WorkloadReport{
+		Caregivers:  make([]CaregiverStats, 0),
+		NightWindow: window,
+		NightWeight: nightWeight,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+
+	authors := make(map[string]string)
+	for _, event := range events {
+		authors[fmt.Sprintf("%d:%s", event.Timestamp, event.Name)] = event.Author
+	}
+	authorOf := func(ts int64, names ...string) string {
+		for _, name := range names {
+			if author := authors[fmt.Sprintf("%d:%s", ts, name)]; author != "" {
+				return author
+			}
+		}
+		return "None"
+	}
+
+	byAuthor := make(map[string]*CaregiverStats)
+	credit := func(ts int64, count, nightCount *int) {
+		*count++
+		if isNight(ts, window, loc) {
+			*nightCount++
+		}
+	}
+	caregiver := func(author string) *CaregiverStats {
+		if byAuthor[author] == nil {
+			byAuthor[author] = &CaregiverStats{Author: author}
+		}
+		return byAuthor[author]
+	}
+
+	for _, feedStart := range groupFeeds(sessions, end) {
+		if feedStart < start {
+			continue
+		}
+		stats := caregiver(authorOf(feedStart, "leftBoob", "rightBoob"))
+		credit(feedStart, &stats.FeedCount, &stats.NightFeedCount)
+	}
+	for _, session := range sessions {
+		if session.Type == "sleep" && session.Start >= start && session.Start < end {
+			stats := caregiver(authorOf(session.Start, "sleep"))
+			credit(session.Start, &stats.SettlingCount, &stats.NightSettlingCount)
+		}
+	}
+	lastDiaper := make(map[string]int64)
+	for _, event := range events {
+		if (event.Name != "pee" && event.Name != "poop") || event.Timestamp < start || event.Timestamp >= end {
+			continue
+		}
+		author := event.Author
+		if author == "" {
+			author = "None"
+		}
+		last, seen := lastDiaper[author]
+		lastDiaper[author] = event.Timestamp
+		if seen && event.Timestamp-last <= diaperMergeGap {
+			continue
+		}
+		stats := caregiver(author)
+		credit(event.Timestamp, &stats.DiaperCount, &stats.NightDiaperCount)
+	}
+
+	var total float64
+	for _, stats := range byAuthor {
+		tasks := stats.FeedCount + stats.DiaperCount + stats.SettlingCount
+		nightTasks := stats.NightFeedCount + stats.NightDiaperCount + stats.NightSettlingCount
+		stats.Score = float64(tasks-nightTasks) + float64(nightTasks)*nightWeight
+		total += stats.Score
+		report.Caregivers = append(report.Caregivers, *stats)
+	}
+	for i := range report.Caregivers {
+		if total > 0 {
+			report.Caregivers[i].Share = report.Caregivers[i].Score / total
+		}
+	}
+	sort.Slice(report.Caregivers, func(i, j int) bool {
+		a, b := report.Caregivers[i], report.Caregivers[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Author < b.Author
+	})
+	return report
+}
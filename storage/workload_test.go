@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeWorkload(t *testing.T) {
+	loc := time.UTC
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, loc)
+	at := func(hour, minute int) int64 {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute).UnixMilli()
+	}
+	events := []DBBabyEvent{
+		{Timestamp: at(2, 0), Name: "leftBoob", Author: "Mathilde"}, // Night feed
+		{Timestamp: at(2, 15), Name: "leftBoobStop", Author: "Mathilde"},
+		{Timestamp: at(2, 18), Name: "rightBoob", Author: "Mathilde"}, // Same feed
+		{Timestamp: at(2, 30), Name: "rightBoobStop", Author: "Mathilde"},
+		{Timestamp: at(2, 35), Name: "pee", Author: "Felix"},       // Night diaper
+		{Timestamp: at(2, 36), Name: "poop", Author: "Felix"},      // Same change
+		{Timestamp: at(2, 40), Name: "sleep", Author: "Felix"},     // Night settling
+		{Timestamp: at(10, 0), Name: "rightBoob", Author: "Felix"}, // Day feed, ends the sleep
+		{Timestamp: at(10, 20), Name: "rightBoobStop", Author: "Felix"},
+		{Timestamp: at(11, 0), Name: "pee"}, // No author
+	}
+	start, end := at(0, 0), at(24, 0)
+	sessions, _ := pairSessions(sessionState{}, events, end)
+	report := computeWorkload(sessions, events, start, end, DefaultNightWindow, 2, loc)
+
+	if len(report.Caregivers) != 3 {
+		t.Fatalf("Expected 3 caregivers, got %+v", report.Caregivers)
+	}
+	felix, mathilde, none := report.Caregivers[0], report.Caregivers[1], report.Caregivers[2]
+	if felix.Author != "Felix" || felix.FeedCount != 1 || felix.NightFeedCount != 0 || felix.DiaperCount != 1 ||
+		felix.NightDiaperCount != 1 || felix.SettlingCount != 1 || felix.NightSettlingCount != 1 || felix.Score != 5 {
+		t.Errorf("Unexpected stats for Felix: %+v", felix)
+	}
+	if mathilde.Author != "Mathilde" || mathilde.FeedCount != 1 || mathilde.NightFeedCount != 1 || mathilde.Score != 2 {
+		t.Errorf("Unexpected stats for Mathilde: %+v", mathilde)
+	}
+	if none.Author != "None" || none.DiaperCount != 1 || none.Score != 1 {
+		t.Errorf("Unexpected stats without author: %+v", none)
+	}
+	if share := felix.Share + mathilde.Share + none.Share; share < 0.999 || share > 1.001 {
+		t.Errorf("Expected shares to add up to 1, got %f", share)
+	}
+}